@@ -0,0 +1,765 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tebeka/selenium"
+)
+
+// bidiTransport wraps selenium's HTTP client to request a WebDriver BiDi WebSocket
+// when the session is created. The selenium package only forwards W3C capability
+// names it knows about, so webSocketUrl is added to the request here and the URL
+// geckodriver returns is captured from the response.
+type bidiTransport struct {
+	base         http.RoundTripper
+	webSocketURL string
+}
+
+// enableBiDi installs the transport for the next session created with selenium.NewRemote
+func enableBiDi() *bidiTransport {
+	transport := &bidiTransport{base: http.DefaultTransport}
+	selenium.HTTPClient = &http.Client{Transport: transport}
+	return transport
+}
+
+func (t *bidiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/session") || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if caps, ok := payload["capabilities"].(map[string]interface{}); ok {
+			if alwaysMatch, ok := caps["alwaysMatch"].(map[string]interface{}); ok {
+				alwaysMatch["webSocketUrl"] = true
+				if updated, err := json.Marshal(payload); err == nil {
+					body = updated
+				}
+			}
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var reply struct {
+		Value struct {
+			Capabilities struct {
+				WebSocketURL string `json:"webSocketUrl"`
+			} `json:"capabilities"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(respBody, &reply); err == nil {
+		t.webSocketURL = reply.Value.Capabilities.WebSocketURL
+	}
+	return resp, nil
+}
+
+// bidiSession is a minimal WebDriver BiDi client: commands are matched to their
+// responses by id, and events are dispatched to handlers registered with On.
+type bidiSession struct {
+	conn *websocket.Conn
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan bidiMessage
+	// handlers run on the reader goroutine and must not call back into the session
+	handlers map[string][]func(json.RawMessage)
+	closed   chan struct{}
+}
+
+type bidiMessage struct {
+	Type    string          `json:"type"`
+	ID      *int            `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+	Message string          `json:"message"`
+}
+
+func connectBiDi(url string) (*bidiSession, error) {
+	if url == "" {
+		return nil, fmt.Errorf("geckodriver did not return a WebSocket URL")
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %v", url, err)
+	}
+
+	b := &bidiSession{
+		conn:     conn,
+		pending:  map[int]chan bidiMessage{},
+		handlers: map[string][]func(json.RawMessage){},
+		closed:   make(chan struct{}),
+	}
+	go b.readLoop()
+	return b, nil
+}
+
+func (b *bidiSession) readLoop() {
+	defer close(b.closed)
+	for {
+		var msg bidiMessage
+		if err := b.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if msg.ID != nil {
+			b.mu.Lock()
+			ch := b.pending[*msg.ID]
+			delete(b.pending, *msg.ID)
+			b.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+			continue
+		}
+
+		if msg.Type == "event" {
+			b.mu.Lock()
+			handlers := b.handlers[msg.Method]
+			b.mu.Unlock()
+			for _, handler := range handlers {
+				handler(msg.Params)
+			}
+		}
+	}
+}
+
+// On registers a handler for a BiDi event
+func (b *bidiSession) On(event string, handler func(params json.RawMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[event] = append(b.handlers[event], handler)
+}
+
+// Call sends a command and waits for its result
+func (b *bidiSession) Call(method string, params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	ch := make(chan bidiMessage, 1)
+	b.pending[id] = ch
+	b.mu.Unlock()
+
+	b.writeMu.Lock()
+	err := b.conn.WriteJSON(map[string]interface{}{"id": id, "method": method, "params": params})
+	b.writeMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", method, err)
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Type == "error" {
+			return nil, fmt.Errorf("%s: %s: %s", method, msg.Error, msg.Message)
+		}
+		return msg.Result, nil
+	case <-b.closed:
+		return nil, fmt.Errorf("%s: connection closed", method)
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("%s: timed out", method)
+	}
+}
+
+// Close closes the WebSocket connection
+func (b *bidiSession) Close() {
+	b.conn.Close()
+}
+
+// consoleEntry is a console message reported through log.entryAdded
+type consoleEntry struct {
+	Level     string
+	Text      string
+	Timestamp int64
+	Source    string // call site as url:line, if known
+}
+
+// networkExchange is one request/response pair; each hop of a redirect is its own exchange
+type networkExchange struct {
+	RequestID     string
+	RedirectCount int
+	URL           string
+	Method        string
+	Context       string
+	Navigation    string
+	Status        int
+	StatusText    string
+	Headers       http.Header
+	Error         string
+	Initiator     string
+	Done          bool
+	InFlight      bool // counted in eventCapture.inflight until it completes or fails
+}
+
+// eventCapture records console, error and network events over WebDriver BiDi from
+// the start of the session, so nothing logged during page load or across navigations is lost
+type eventCapture struct {
+	bidi       *bidiSession
+	topContext string
+	dialogs    dialogOptions
+
+	mu              sync.Mutex
+	navigationStart int64 // timestamp of the first top-level navigation, in ms
+	console         []consoleEntry
+	errors          []pageError
+	logErrors       []pageError
+	exchanges       map[string][]*networkExchange // redirect hops by request id, dropped once complete
+	document        string                        // request id of the current top-level navigation
+	networkErrors   []networkError
+	dialogLog       []dialogRecord
+	lastEvent       time.Time
+	inflight        int       // requests sent but not yet completed or failed
+	lastNetwork     time.Time // time of the most recent network event
+}
+
+// bidiEvents are the events subscribed to for every session
+var bidiEvents = []string{
+	"log.entryAdded",
+	"network.beforeRequestSent",
+	"network.responseCompleted",
+	"network.fetchError",
+	"script.message",
+	"browsingContext.navigationStarted",
+	"browsingContext.userPromptOpened",
+}
+
+// pageError is an uncaught exception or unhandled promise rejection
+type pageError struct {
+	Kind      string `json:"kind"` // "error", "unhandledrejection" or "javascript" (reported only by the browser log)
+	Message   string `json:"message"`
+	Source    string `json:"source,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Stack     string `json:"stack,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+const errorsChannel = "web-page-errors"
+
+// errorCaptureScript is installed as a preload script so it runs in every document
+// before the page's own scripts, reporting errors back over a BiDi channel
+const errorCaptureScript = `(channel) => {
+	const report = (kind, message, error, source, line, column) => {
+		try {
+			channel(JSON.stringify({
+				kind: kind,
+				message: String(message),
+				source: source || (error && error.fileName) || '',
+				line: line || (error && error.lineNumber) || 0,
+				column: column || (error && error.columnNumber) || 0,
+				stack: (error && typeof error.stack === 'string') ? error.stack : '',
+				timestamp: Date.now()
+			}));
+		} catch (e) {}
+	};
+	window.addEventListener('error', (event) => {
+		// Failed resource loads also dispatch error events, but without an ErrorEvent
+		if (!(event instanceof ErrorEvent)) {
+			return;
+		}
+		report('error', event.message, event.error, event.filename, event.lineno, event.colno);
+	}, true);
+	window.addEventListener('unhandledrejection', (event) => {
+		const reason = event.reason;
+		const message = (reason && reason.message !== undefined)
+			? (reason.name || 'Error') + ': ' + reason.message
+			: String(reason);
+		report('unhandledrejection', message, reason, '', 0, 0);
+	});
+}`
+
+// startEventCapture connects to the session's BiDi endpoint and subscribes to events,
+// answering dialogs as configured
+func startEventCapture(webSocketURL string, dialogs dialogOptions) (*eventCapture, error) {
+	b, err := connectBiDi(webSocketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	capture := &eventCapture{bidi: b, dialogs: dialogs, lastEvent: time.Now(), exchanges: map[string][]*networkExchange{}}
+	b.On("log.entryAdded", capture.onLogEntry)
+	b.On("network.beforeRequestSent", capture.onRequest)
+	b.On("network.responseCompleted", capture.onResponse)
+	b.On("network.fetchError", capture.onFetchError)
+	b.On("script.message", capture.onScriptMessage)
+	b.On("browsingContext.navigationStarted", capture.onNavigationStarted)
+	b.On("browsingContext.userPromptOpened", capture.onUserPromptOpened)
+
+	result, err := b.Call("browsingContext.getTree", map[string]interface{}{"maxDepth": 0})
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	var tree struct {
+		Contexts []struct {
+			Context string `json:"context"`
+		} `json:"contexts"`
+	}
+	if err := json.Unmarshal(result, &tree); err == nil && len(tree.Contexts) > 0 {
+		capture.topContext = tree.Contexts[0].Context
+	}
+
+	if _, err := b.Call("session.subscribe", map[string]interface{}{"events": bidiEvents}); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	_, err = b.Call("script.addPreloadScript", map[string]interface{}{
+		"functionDeclaration": errorCaptureScript,
+		"arguments": []interface{}{
+			map[string]interface{}{"type": "channel", "value": map[string]interface{}{"channel": errorsChannel}},
+		},
+	})
+	if err != nil {
+		statusf("Warning: Could not install error capture: %v", err)
+	}
+	return capture, nil
+}
+
+// Close ends the BiDi connection
+func (c *eventCapture) Close() {
+	c.bidi.Close()
+}
+
+// Flush waits for events still in flight from the browser to be delivered
+func (c *eventCapture) Flush() {
+	c.bidi.Call("session.status", nil)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		quiet := time.Since(c.lastEvent) >= 100*time.Millisecond
+		c.mu.Unlock()
+		if quiet {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (c *eventCapture) onLogEntry(params json.RawMessage) {
+	var entry struct {
+		Type       string `json:"type"`
+		Level      string `json:"level"`
+		Method     string `json:"method"`
+		Text       string `json:"text"`
+		Timestamp  int64  `json:"timestamp"`
+		StackTrace struct {
+			CallFrames []struct {
+				FunctionName string `json:"functionName"`
+				URL          string `json:"url"`
+				LineNumber   int    `json:"lineNumber"`
+				ColumnNumber int    `json:"columnNumber"`
+			} `json:"callFrames"`
+		} `json:"stackTrace"`
+	}
+	if err := json.Unmarshal(params, &entry); err != nil {
+		return
+	}
+
+	// Uncaught errors are reported in the ERRORS section rather than as console output
+	if entry.Type == "javascript" {
+		pe := pageError{Kind: "javascript", Message: entry.Text, Timestamp: entry.Timestamp}
+		var stack []string
+		for i, frame := range entry.StackTrace.CallFrames {
+			// BiDi line and column numbers are zero-based
+			if i == 0 {
+				pe.Source, pe.Line, pe.Column = frame.URL, frame.LineNumber+1, frame.ColumnNumber+1
+			}
+			stack = append(stack, fmt.Sprintf("%s@%s:%d:%d", frame.FunctionName, frame.URL, frame.LineNumber+1, frame.ColumnNumber+1))
+		}
+		pe.Stack = strings.Join(stack, "\n")
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.logErrors = append(c.logErrors, pe)
+		c.lastEvent = time.Now()
+		return
+	}
+
+	// Console entries are labelled by the console method used (log/warn/error/info/debug),
+	// JavaScript errors by their severity
+	level := entry.Level
+	if entry.Type == "console" && entry.Method != "" {
+		level = entry.Method
+	}
+	level = strings.ToUpper(level)
+	if level == "WARN" {
+		level = "WARNING"
+	}
+
+	source := ""
+	if frames := entry.StackTrace.CallFrames; len(frames) > 0 && frames[0].URL != "" {
+		source = fmt.Sprintf("%s:%d", frames[0].URL, frames[0].LineNumber+1)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.console = append(c.console, consoleEntry{Level: level, Text: entry.Text, Timestamp: entry.Timestamp, Source: source})
+	c.lastEvent = time.Now()
+}
+
+func (c *eventCapture) onNavigationStarted(params json.RawMessage) {
+	var nav struct {
+		Context   string `json:"context"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.Unmarshal(params, &nav); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Console timestamps are reported relative to the first top-level navigation
+	if nav.Context == c.topContext && c.navigationStart == 0 {
+		c.navigationStart = nav.Timestamp
+	}
+}
+
+type bidiNetworkParams struct {
+	Context       string `json:"context"`
+	Navigation    string `json:"navigation"`
+	RedirectCount int    `json:"redirectCount"`
+	Request       struct {
+		Request       string `json:"request"`
+		URL           string `json:"url"`
+		Method        string `json:"method"`
+		InitiatorType string `json:"initiatorType"`
+		Destination   string `json:"destination"`
+		Headers       []struct {
+			Name  string `json:"name"`
+			Value struct {
+				Value string `json:"value"`
+			} `json:"value"`
+		} `json:"headers"`
+	} `json:"request"`
+	Initiator struct {
+		Type string `json:"type"`
+	} `json:"initiator"`
+	Response struct {
+		Status     int    `json:"status"`
+		StatusText string `json:"statusText"`
+		Headers    []struct {
+			Name  string `json:"name"`
+			Value struct {
+				Value string `json:"value"`
+			} `json:"value"`
+		} `json:"headers"`
+	} `json:"response"`
+	ErrorText string `json:"errorText"`
+}
+
+// exchange returns the exchange for an event's request and redirect hop, creating it if needed.
+// Callers must hold c.mu.
+func (c *eventCapture) exchange(p *bidiNetworkParams) *networkExchange {
+	hops := c.exchanges[p.Request.Request]
+	for _, ex := range hops {
+		if ex.RedirectCount == p.RedirectCount {
+			return ex
+		}
+	}
+	ex := &networkExchange{
+		RequestID:     p.Request.Request,
+		RedirectCount: p.RedirectCount,
+		URL:           p.Request.URL,
+		Method:        p.Request.Method,
+		Context:       p.Context,
+		Navigation:    p.Navigation,
+	}
+	// Prefer the resource timing initiator type ("img", "fetch", ...), falling back
+	// to the request destination and the coarser BiDi initiator ("parser", "script")
+	for _, initiator := range []string{p.Request.InitiatorType, p.Request.Destination, p.Initiator.Type} {
+		if initiator != "" {
+			ex.Initiator = initiator
+			break
+		}
+	}
+	c.exchanges[ex.RequestID] = append(hops, ex)
+
+	// A new top-level navigation replaces the document; forget the previous one
+	if ex.Navigation != "" && ex.Context == c.topContext && ex.RedirectCount == 0 && ex.RequestID != c.document {
+		previous := c.document
+		c.document = ex.RequestID
+		if !inFlight(c.exchanges[previous]) {
+			delete(c.exchanges, previous)
+		}
+	}
+	return ex
+}
+
+// inFlight reports whether any redirect hop of a request is still waiting for its response
+func inFlight(hops []*networkExchange) bool {
+	for _, ex := range hops {
+		if !ex.Done {
+			return true
+		}
+	}
+	return false
+}
+
+// longLived reports whether a request stays open by design (WebSockets, like the LiveView
+// socket, and server-sent event streams), so it never counts against network idle
+func (p *bidiNetworkParams) longLived() bool {
+	for _, header := range p.Request.Headers {
+		name, value := strings.ToLower(header.Name), strings.ToLower(header.Value.Value)
+		if (name == "upgrade" && value == "websocket") || (name == "accept" && strings.Contains(value, "text/event-stream")) {
+			return true
+		}
+	}
+	return false
+}
+
+// finish marks an exchange as complete, taking it out of the in-flight count and recording
+// it if it failed. Only the current document's exchanges are kept once complete.
+// Callers must hold c.mu.
+func (c *eventCapture) finish(ex *networkExchange) {
+	c.lastNetwork = time.Now()
+	c.lastEvent = c.lastNetwork
+	if ex.Done {
+		return
+	}
+	if ex.InFlight {
+		ex.InFlight = false
+		c.inflight--
+	}
+	ex.Done = true
+
+	// Top-level navigations are left out since their status is reported in the output header
+	topLevel := ex.Navigation != "" && ex.Context == c.topContext
+	if !topLevel && (ex.Error != "" || ex.Status >= 400) {
+		c.networkErrors = append(c.networkErrors, networkError{
+			URL:        ex.URL,
+			Method:     ex.Method,
+			Status:     ex.Status,
+			StatusText: ex.StatusText,
+			Error:      ex.Error,
+			Initiator:  ex.Initiator,
+		})
+	}
+	if ex.RequestID != c.document && !inFlight(c.exchanges[ex.RequestID]) {
+		delete(c.exchanges, ex.RequestID)
+	}
+}
+
+func (c *eventCapture) onRequest(params json.RawMessage) {
+	var p bidiNetworkParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ex := c.exchange(&p)
+	// A new redirect hop ends the previous one, even if its response was not reported
+	for _, hop := range c.exchanges[ex.RequestID] {
+		if hop.RedirectCount < ex.RedirectCount && hop.InFlight {
+			hop.InFlight = false
+			c.inflight--
+		}
+	}
+	if !ex.Done && !ex.InFlight && !p.longLived() {
+		ex.InFlight = true
+		c.inflight++
+	}
+	c.lastNetwork = time.Now()
+	c.lastEvent = c.lastNetwork
+}
+
+func (c *eventCapture) onResponse(params json.RawMessage) {
+	var p bidiNetworkParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ex := c.exchange(&p)
+	ex.Status = p.Response.Status
+	ex.StatusText = p.Response.StatusText
+	ex.Headers = http.Header{}
+	for _, header := range p.Response.Headers {
+		ex.Headers.Add(header.Name, header.Value.Value)
+	}
+	c.finish(ex)
+}
+
+func (c *eventCapture) onFetchError(params json.RawMessage) {
+	var p bidiNetworkParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ex := c.exchange(&p)
+	ex.Error = p.ErrorText
+	c.finish(ex)
+}
+
+func (c *eventCapture) onScriptMessage(params json.RawMessage) {
+	var msg struct {
+		Channel string `json:"channel"`
+		Data    struct {
+			Value string `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(params, &msg); err != nil || msg.Channel != errorsChannel {
+		return
+	}
+
+	var pe pageError
+	if err := json.Unmarshal([]byte(msg.Data.Value), &pe); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, pe)
+	c.lastEvent = time.Now()
+}
+
+// Errors returns uncaught exceptions and unhandled rejections in the order they happened.
+// Errors seen by the page hooks carry the most detail; browser log errors are only added
+// when no hook reported them (e.g. errors thrown in workers).
+func (c *eventCapture) Errors() []pageError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := append([]pageError{}, c.errors...)
+	for _, logErr := range c.logErrors {
+		duplicate := false
+		for _, pe := range c.errors {
+			if errorMessagesMatch(pe.Message, logErr.Message) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			errs = append(errs, logErr)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Timestamp < errs[j].Timestamp })
+
+	for i := range errs {
+		errs[i].Message = redact(errs[i].Message)
+		errs[i].Stack = redact(errs[i].Stack)
+	}
+	return errs
+}
+
+// errorMessagesMatch reports whether two messages describe the same error once the
+// "Uncaught"- and "Error:"-style prefixes browsers add are stripped; empty messages never match
+func errorMessagesMatch(a, b string) bool {
+	normalize := func(s string) string {
+		s = strings.TrimSpace(s)
+		for _, prefix := range []string{"Uncaught (in promise) ", "Uncaught ", "uncaught exception: ", "Error: "} {
+			s = strings.TrimPrefix(s, prefix)
+		}
+		return s
+	}
+	a, b = normalize(a), normalize(b)
+	return a != "" && a == b
+}
+
+// networkError is a subresource request that failed or returned an error status
+type networkError struct {
+	URL        string `json:"url"`
+	Method     string `json:"method"`
+	Status     int    `json:"status,omitempty"`
+	StatusText string `json:"status_text,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Initiator  string `json:"initiator,omitempty"`
+}
+
+// NetworkErrors returns subresource requests that failed outright (DNS, CORS, blocked)
+// or completed with a 4xx/5xx status, in the order they completed
+func (c *eventCapture) NetworkErrors() []networkError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := make([]networkError, len(c.networkErrors))
+	for i, ne := range c.networkErrors {
+		ne.URL = redact(ne.URL)
+		failed[i] = ne
+	}
+	return failed
+}
+
+// ConsoleMessages returns the captured console messages in chronological order,
+// filtered and formatted according to opts
+func (c *eventCapture) ConsoleMessages(opts consoleOptions) []string {
+	c.mu.Lock()
+	entries := append([]consoleEntry{}, c.console...)
+	start := c.navigationStart
+	c.mu.Unlock()
+
+	return formatConsole(entries, start, opts)
+}
+
+// NetworkIdle reports whether no request is in flight and the network has been quiet for idle
+func (c *eventCapture) NetworkIdle(idle time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inflight == 0 && time.Since(c.lastNetwork) >= idle
+}
+
+// DocumentInfo returns the response and redirect chain of the current top-level document,
+// provided its navigation started at startURL
+func (c *eventCapture) DocumentInfo(startURL string) *documentInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hops := append([]*networkExchange{}, c.exchanges[c.document]...)
+	sort.Slice(hops, func(i, j int) bool { return hops[i].RedirectCount < hops[j].RedirectCount })
+	if len(hops) == 0 || !sameURL(hops[0].URL, startURL) {
+		return nil
+	}
+
+	info := &documentInfo{}
+	for _, ex := range hops {
+		if !ex.Done {
+			continue
+		}
+		if ex.Status >= 300 && ex.Status < 400 && ex.Headers.Get("Location") != "" {
+			info.Redirects = append(info.Redirects, redirectHop{URL: ex.URL, Status: ex.Status})
+			continue
+		}
+		info.Status = ex.Status
+		info.StatusText = ex.StatusText
+		info.Headers = ex.Headers
+		info.FinalURL = ex.URL
+	}
+	if info.Status == 0 {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// consoleLevels orders console levels for --console-level filtering
+var consoleLevels = map[string]int{
+	"DEBUG":   0,
+	"LOG":     1,
+	"INFO":    1,
+	"WARNING": 2,
+	"ERROR":   3,
+}
+
+// consoleOptions controls which console messages are shown and how many
+type consoleOptions struct {
+	MinLevel string         // lowest level to show, e.g. "WARNING"
+	Grep     *regexp.Regexp // only show messages matching this pattern
+	Limit    int            // maximum number of (collapsed) messages, 0 for no limit
+}
+
+// parseConsoleLevel normalizes a --console-level argument
+func parseConsoleLevel(arg string) (string, error) {
+	level := strings.ToUpper(arg)
+	if level == "WARN" {
+		level = "WARNING"
+	}
+	if _, ok := consoleLevels[level]; !ok {
+		return "", fmt.Errorf("invalid --console-level: %s (expected debug, log, info, warn or error)", arg)
+	}
+	return level, nil
+}
+
+// formatConsole sorts entries chronologically, applies filters, collapses consecutive
+// repeats into "(xN)" and renders each as "[+123ms] [LEVEL] message (url:line)"
+func formatConsole(entries []consoleEntry, navigationStart int64, opts consoleOptions) []string {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+
+	if navigationStart == 0 && len(entries) > 0 {
+		navigationStart = entries[0].Timestamp
+	}
+
+	type group struct {
+		entry consoleEntry
+		count int
+	}
+	var groups []group
+	for _, entry := range entries {
+		if opts.MinLevel != "" && consoleLevels[entry.Level] < consoleLevels[opts.MinLevel] {
+			continue
+		}
+		if opts.Grep != nil && !opts.Grep.MatchString(entry.Text) {
+			continue
+		}
+
+		last := len(groups) - 1
+		if last >= 0 && groups[last].entry.Level == entry.Level && groups[last].entry.Text == entry.Text && groups[last].entry.Source == entry.Source {
+			groups[last].count++
+			continue
+		}
+		groups = append(groups, group{entry: entry, count: 1})
+	}
+
+	omitted := 0
+	if opts.Limit > 0 && len(groups) > opts.Limit {
+		omitted = len(groups) - opts.Limit
+		groups = groups[:opts.Limit]
+	}
+
+	var messages []string
+	for _, g := range groups {
+		msg := fmt.Sprintf("[%+dms] [%s] %s", g.entry.Timestamp-navigationStart, g.entry.Level, g.entry.Text)
+		if g.entry.Source != "" {
+			msg += fmt.Sprintf(" (%s)", g.entry.Source)
+		}
+		if g.count > 1 {
+			msg += fmt.Sprintf(" (x%d)", g.count)
+		}
+		messages = append(messages, redact(msg))
+	}
+	if omitted > 0 {
+		messages = append(messages, fmt.Sprintf("... (%d more messages omitted, see --console-limit)", omitted))
+	}
+	return messages
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const DEFAULT_CRAWL_DEPTH = 2
+
+const DEFAULT_CRAWL_MAX_PAGES = 100
+
+// crawlManifestFile is written into the output directory alongside the page files
+const crawlManifestFile = "index.json"
+
+// crawlSkipExtensions are link targets that are downloads or assets rather than pages
+var crawlSkipExtensions = regexp.MustCompile(`(?i)\.(pdf|zip|gz|tgz|tar|dmg|exe|msi|png|jpe?g|gif|webp|svg|ico|mp3|mp4|webm|mov|avi|woff2?|ttf|css|js|json|xml|rss|atom)$`)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// linksScript returns the absolute URLs of all links on the page, including inside open
+// shadow roots in shadow DOM mode
+var linksScript = queryAllScript + `
+	return webQueryAll('a[href]', arguments[0]).map(function(a) { return a.href; });
+`
+
+// crawlPage is one manifest entry
+type crawlPage struct {
+	URL    string `json:"url"`
+	File   string `json:"file,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Depth  int    `json:"depth"`
+	Error  string `json:"error,omitempty"`
+}
+
+// crawlManifest is the index of a crawl, written as index.json
+type crawlManifest struct {
+	StartURL       string      `json:"start_url,omitempty"`
+	Sitemap        string      `json:"sitemap,omitempty"`
+	MaxDepth       int         `json:"max_depth"`
+	Pages          []crawlPage `json:"pages"`
+	RobotsSkipped  []string    `json:"robots_skipped,omitempty"`
+	PatternSkipped int         `json:"pattern_skipped,omitempty"`
+}
+
+type crawlItem struct {
+	URL   string
+	Depth int
+}
+
+// runCrawl renders every same-origin page reachable from the start URL within the depth
+// limit, or every page listed by --sitemap, in one browser session, writing each page's
+// content to its own file in the output directory and a manifest of all pages. The start
+// URL, when given, decides which site is crawled; the sitemap may live on another host.
+func runCrawl(config Config) (string, error) {
+	siteURL := ensureProtocol(config.URL)
+	sitemapURL := ""
+	if config.SitemapURL != "" {
+		sitemapURL = resolveSitemapURL(config.URL, config.SitemapURL)
+		if config.URL == "" {
+			siteURL = sitemapURL
+		}
+	}
+	start, err := canonicalURL(siteURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid crawl URL %s: %v", siteURL, err)
+	}
+	manifest := crawlManifest{MaxDepth: config.CrawlDepth}
+	if config.URL != "" {
+		manifest.StartURL = redact(start.String())
+	}
+	seen := map[string]bool{}
+	var queue []crawlItem
+	if sitemapURL != "" {
+		// Pages come from the sitemap instead of link discovery
+		urls, err := loadSitemap(sitemapURL, config.Since, config.WaitTimeout)
+		if err != nil {
+			return "", err
+		}
+		manifest.Sitemap = redact(sitemapURL)
+		manifest.MaxDepth = 0
+		for _, link := range urls {
+			u, err := canonicalURL(link)
+			if err != nil || seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			if !sameOrigin(u, start) {
+				statusf("Skipping %s (not on %s)", link, start.Host)
+				continue
+			}
+			if !crawlPatternAllowed(u, config.Include, config.Exclude) {
+				manifest.PatternSkipped++
+				continue
+			}
+			queue = append(queue, crawlItem{URL: u.String(), Depth: 0})
+		}
+	} else {
+		seen[start.String()] = true
+		queue = append(queue, crawlItem{URL: start.String(), Depth: 0})
+	}
+
+	outDir := config.OutDir
+	if outDir == "" {
+		outDir = start.Hostname()
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", fmt.Errorf("could not create output directory: %v", err)
+	}
+
+	robots := fetchRobots(start, config.WaitTimeout)
+
+	session, err := startSession(config)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	wd := session.wd
+
+	files := map[string]bool{crawlManifestFile: true}
+	var failure error
+
+	for len(queue) > 0 && len(manifest.Pages) < config.MaxPages {
+		item := queue[0]
+		queue = queue[1:]
+
+		target, _ := url.Parse(item.URL)
+		if !robots.Allowed(target) {
+			statusf("Skipping %s (disallowed by robots.txt)", item.URL)
+			manifest.RobotsSkipped = append(manifest.RobotsSkipped, redact(item.URL))
+			continue
+		}
+		if len(manifest.Pages) > 0 && robots.Delay > 0 {
+			time.Sleep(robots.Delay)
+		}
+
+		statusf("[%d/%d] Crawling %s (depth %d)", len(manifest.Pages)+1, config.MaxPages, item.URL, item.Depth)
+		page, content, links := crawlOne(session, config, item)
+
+		// Redirects can land on a page that was already crawled, or leave the site
+		if page.Error == "" {
+			finalURL, _ := wd.CurrentURL()
+			if final, err := canonicalURL(finalURL); err == nil && final.String() != item.URL {
+				if !sameOrigin(final, start) {
+					statusf("Skipping %s (redirected off-site to %s)", item.URL, final)
+					continue
+				}
+				if seen[final.String()] {
+					statusf("Skipping %s (redirected to already crawled %s)", item.URL, final)
+					continue
+				}
+				seen[final.String()] = true
+			}
+		}
+
+		if page.Error == "" {
+			file := crawlFileName(target, config.RawFlag, files)
+			if err := os.MkdirAll(filepath.Join(outDir, filepath.Dir(file)), 0755); err != nil {
+				return "", fmt.Errorf("could not create output directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(outDir, file), []byte(redact(content)), 0644); err != nil {
+				return "", fmt.Errorf("could not write %s: %v", file, err)
+			}
+			page.File = file
+		}
+		page.URL = redact(page.URL)
+		page.Error = redact(page.Error)
+		manifest.Pages = append(manifest.Pages, page)
+		if failure == nil && config.FailOnHTTPError && page.Status >= 400 {
+			failure = &checkFailedError{fmt.Sprintf("%s returned HTTP %d", page.URL, page.Status)}
+		}
+
+		if config.SitemapURL != "" || item.Depth >= config.CrawlDepth {
+			continue
+		}
+		for _, link := range links {
+			u, err := canonicalURL(link)
+			if err != nil || !sameOrigin(u, start) || seen[u.String()] || crawlSkipExtensions.MatchString(u.Path) {
+				continue
+			}
+			seen[u.String()] = true
+			if !crawlPatternAllowed(u, config.Include, config.Exclude) {
+				manifest.PatternSkipped++
+				continue
+			}
+			queue = append(queue, crawlItem{URL: u.String(), Depth: item.Depth + 1})
+		}
+	}
+	if len(queue) > 0 {
+		statusf("Stopped after --max-pages %d with %d discovered page(s) not crawled", config.MaxPages, len(queue))
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not encode manifest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, crawlManifestFile), data, 0644); err != nil {
+		return "", fmt.Errorf("could not write manifest: %v", err)
+	}
+
+	// Save recorded network traffic
+	if err := session.SaveHAR(); err != nil {
+		return "", err
+	}
+
+	if config.JSONFlag {
+		return string(data), failure
+	}
+	return formatCrawlReport(manifest, outDir), failure
+}
+
+// crawlOne renders one page with the configured waits and returns its manifest entry,
+// converted content and link URLs
+func crawlOne(session *browserSession, config Config, item crawlItem) (crawlPage, string, []string) {
+	wd, events := session.wd, session.events
+	page := crawlPage{URL: item.URL, Depth: item.Depth}
+	if err := wd.Get(item.URL); err != nil {
+		page.Error = fmt.Sprintf("could not navigate: %v", err)
+		return page, "", nil
+	}
+	if config.WaitUntil == "networkidle" {
+		waitForNetworkIdle(wd, events, config.IdleTime, config.WaitTimeout)
+	}
+	if err := waitForConditions(wd, config.Waits, config.WaitTimeout); err != nil {
+		statusf("Warning: %v", err)
+	}
+	if config.WaitStable > 0 {
+		waitForDOMStable(wd, config.WaitStable, config.WaitTimeout)
+	}
+	if config.Scroll {
+		scrollPage(wd, config.MaxScrolls, config.ScrollDelay, config.WaitTimeout)
+	}
+
+	if info := session.DocumentInfo(item.URL); info != nil {
+		page.Status = info.Status
+	}
+	page.Title, _ = wd.Title()
+
+	captured, err := capturePageContent(wd, config)
+	if err != nil {
+		page.Error = err.Error()
+		return page, "", nil
+	}
+	content, fullLength, err := pagesOutput([]capturedPage{captured}, config.RawFlag)
+	if err != nil {
+		page.Error = err.Error()
+		return page, "", nil
+	}
+	if !config.RawFlag {
+		content, _ = truncateOutput(content, fullLength, config.TruncateAfter)
+	}
+
+	var links []string
+	raw, err := wd.ExecuteScriptRaw(linksScript, []interface{}{shadowDOMMode})
+	if err == nil {
+		var reply struct {
+			Value []string `json:"value"`
+		}
+		err = json.Unmarshal(raw, &reply)
+		links = reply.Value
+	}
+	if err != nil {
+		statusf("Warning: Could not collect links: %v", err)
+	}
+	return page, content, links
+}
+
+// formatCrawlReport summarizes a crawl, one line per page
+func formatCrawlReport(manifest crawlManifest, outDir string) string {
+	var b strings.Builder
+	source := manifest.StartURL
+	if manifest.Sitemap != "" {
+		source = "sitemap " + manifest.Sitemap
+	}
+	fmt.Fprintf(&b, "Crawled %d page(s) from %s into %s (manifest: %s)\n", len(manifest.Pages), source, outDir, filepath.Join(outDir, crawlManifestFile))
+	for _, page := range manifest.Pages {
+		status := "---"
+		if page.Status > 0 {
+			status = fmt.Sprint(page.Status)
+		}
+		if page.Error != "" {
+			fmt.Fprintf(&b, "  %s %s  FAILED: %s\n", status, page.URL, page.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s %s -> %s", status, page.URL, page.File)
+		if page.Title != "" {
+			fmt.Fprintf(&b, "  %q", page.Title)
+		}
+		b.WriteString("\n")
+	}
+	if len(manifest.RobotsSkipped) > 0 {
+		fmt.Fprintf(&b, "Skipped %d page(s) disallowed by robots.txt\n", len(manifest.RobotsSkipped))
+	}
+	if manifest.PatternSkipped > 0 {
+		fmt.Fprintf(&b, "Skipped %d link(s) not matching --include/--exclude\n", manifest.PatternSkipped)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// resolveSitemapURL resolves a --sitemap path like /sitemap.xml against the start URL
+func resolveSitemapURL(startURL, sitemapURL string) string {
+	if startURL == "" || !strings.HasPrefix(sitemapURL, "/") {
+		return ensureProtocol(sitemapURL)
+	}
+	base, err := url.Parse(ensureProtocol(startURL))
+	if err != nil {
+		return sitemapURL
+	}
+	ref, err := url.Parse(sitemapURL)
+	if err != nil {
+		return sitemapURL
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// canonicalURL normalizes a URL for deduplication: lowercase scheme and host, no default
+// port or fragment, "/" for an empty path and sorted query parameters
+func canonicalURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+	return u, nil
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && a.Host == b.Host
+}
+
+// crawlPatternAllowed applies --include and --exclude globs to a URL's path (and query)
+func crawlPatternAllowed(u *url.URL, include, exclude []string) bool {
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	for _, pattern := range exclude {
+		if globMatch(pattern, target) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if globMatch(pattern, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches a path glob where * stays within one path segment, ** crosses
+// segments and ? is any single character except /
+func globMatch(pattern, target string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	matched, _ := regexp.MatchString(expr.String(), target)
+	return matched
+}
+
+// crawlFileName maps a page URL to a unique relative file path, e.g. /docs/intro to
+// docs/intro.md and /docs/ to docs/index.md; query strings add a short hash
+func crawlFileName(u *url.URL, raw bool, used map[string]bool) string {
+	ext := ".md"
+	if raw {
+		ext = ".html"
+	}
+
+	name := strings.Trim(u.Path, "/")
+	if name == "" || strings.HasSuffix(u.Path, "/") {
+		name = path.Join(name, "index")
+	}
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".html"), ".htm")
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segment = unsafeFileChars.ReplaceAllString(segment, "_")
+		if segment == "" || segment == "." || segment == ".." {
+			segment = "_"
+		}
+		segments[i] = segment
+	}
+	name = strings.Join(segments, "/")
+	if u.RawQuery != "" {
+		sum := sha1.Sum([]byte(u.RawQuery))
+		name += "-" + hex.EncodeToString(sum[:])[:8]
+	}
+
+	file := name + ext
+	for n := 2; used[file]; n++ {
+		file = fmt.Sprintf("%s-%d%s", name, n, ext)
+	}
+	used[file] = true
+	return filepath.FromSlash(file)
+}
+
+// robotsRules are the robots.txt rules that apply to this tool
+type robotsRules struct {
+	rules []robotsRule
+	Delay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// fetchRobots downloads and parses robots.txt for the site; a missing or unreachable
+// file allows everything
+func fetchRobots(site *url.URL, timeout time.Duration) *robotsRules {
+	robotsURL := &url.URL{Scheme: site.Scheme, Host: site.Host, Path: "/robots.txt"}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(robotsURL.String())
+	if err != nil {
+		statusf("Warning: Could not fetch robots.txt: %v", err)
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}
+	}
+	rules := parseRobots(io.LimitReader(resp.Body, 512*1024))
+	statusf("Loaded robots.txt (%d rule(s))", len(rules.rules))
+	return rules
+}
+
+// parseRobots reads the groups for the "web" user agent, or for "*" when there is none
+func parseRobots(r io.Reader) *robotsRules {
+	type group struct {
+		agents []string
+		rules  robotsRules
+	}
+	var groups []*group
+	var current *group
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow allows everything
+			if current != nil && value != "" {
+				current.rules.rules = append(current.rules.rules, robotsRule{allow: field == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			inAgents = false
+			var seconds float64
+			if current != nil {
+				if _, err := fmt.Sscanf(value, "%g", &seconds); err == nil && seconds > 0 {
+					current.rules.Delay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	var matched, wildcard []*group
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "web" {
+				matched = append(matched, g)
+			} else if agent == "*" {
+				wildcard = append(wildcard, g)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		matched = wildcard
+	}
+	result := &robotsRules{}
+	for _, g := range matched {
+		result.rules = append(result.rules, g.rules.rules...)
+		if g.rules.Delay > result.Delay {
+			result.Delay = g.rules.Delay
+		}
+	}
+	return result
+}
+
+// Allowed applies the longest matching rule, with Allow winning ties
+func (r *robotsRules) Allowed(u *url.URL) bool {
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, target) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a robots.txt path prefix, where * is any sequence and a trailing
+// $ anchors the end
+func robotsMatch(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	matched, _ := regexp.MatchString(expr, target)
+	return matched
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dialogOptions controls how alert, confirm, prompt and beforeunload dialogs are answered
+type dialogOptions struct {
+	Action string // "accept" or "dismiss"; empty dismisses dialogs but lets pages be left
+	Text   string // text entered into prompts, which are then accepted
+}
+
+// dialogRecord is a dialog the page opened and how it was answered
+type dialogRecord struct {
+	Type      string `json:"type"` // "alert", "confirm", "prompt" or "beforeunload"
+	Message   string `json:"message"`
+	Action    string `json:"action"` // "accept" or "dismiss"
+	Text      string `json:"text,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// parseDialogOption parses --dialog accept|dismiss|text=<value>
+func parseDialogOption(arg string) (dialogOptions, error) {
+	switch {
+	case arg == "accept" || arg == "dismiss":
+		return dialogOptions{Action: arg}, nil
+	case strings.HasPrefix(arg, "text="):
+		return dialogOptions{Action: "accept", Text: strings.TrimPrefix(arg, "text=")}, nil
+	}
+	return dialogOptions{}, fmt.Errorf("invalid --dialog value: %s (use accept, dismiss or text=<value>)", arg)
+}
+
+// promptBehavior is the unhandledPromptBehavior capability, so WebDriver commands answer a
+// dialog that is still open instead of failing with "unexpected alert open". Prompt text can
+// only be entered over BiDi, so with text= the browser leaves dialogs for onUserPromptOpened.
+func (o dialogOptions) promptBehavior() string {
+	if o.Text != "" {
+		return "ignore"
+	}
+	if o.Action == "accept" {
+		return "accept"
+	}
+	return "dismiss"
+}
+
+// answer decides whether to accept a dialog of the given type, and the prompt text to enter
+func (o dialogOptions) answer(dialogType string) (bool, string) {
+	if o.Action == "" {
+		// Dismissing beforeunload would cancel the navigation the run asked for
+		return dialogType == "beforeunload", ""
+	}
+	if o.Action == "accept" && dialogType == "prompt" {
+		return true, o.Text
+	}
+	return o.Action == "accept", ""
+}
+
+// onUserPromptOpened records a dialog and answers it unless the browser already handles it
+func (c *eventCapture) onUserPromptOpened(params json.RawMessage) {
+	var prompt struct {
+		Context string `json:"context"`
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		Handler string `json:"handler"` // the prompt handler the browser applies, if any
+	}
+	if err := json.Unmarshal(params, &prompt); err != nil {
+		return
+	}
+
+	record := dialogRecord{Type: prompt.Type, Message: prompt.Message, Timestamp: time.Now().UnixMilli()}
+	if prompt.Handler == "accept" || prompt.Handler == "dismiss" {
+		record.Action = prompt.Handler
+	} else {
+		accept, text := c.dialogs.answer(prompt.Type)
+		record.Action = "dismiss"
+		answer := map[string]interface{}{"context": prompt.Context, "accept": accept}
+		if accept {
+			record.Action = "accept"
+			if text != "" {
+				record.Text = text
+				answer["userText"] = text
+			}
+		}
+		// Answer from a goroutine: the read loop delivering this event also delivers the reply
+		go func() {
+			_, err := c.bidi.Call("browsingContext.handleUserPrompt", answer)
+			// A WebDriver command may have answered it first, following promptBehavior
+			if err != nil && !strings.Contains(err.Error(), "no such alert") {
+				statusf("Warning: Could not answer %s dialog: %v", prompt.Type, err)
+			}
+		}()
+	}
+	statusf("Dialog: %s", formatDialog(record))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialogLog = append(c.dialogLog, record)
+	c.lastEvent = time.Now()
+}
+
+// Dialogs returns the dialogs opened so far, with messages redacted
+func (c *eventCapture) Dialogs() []dialogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dialogs := make([]dialogRecord, len(c.dialogLog))
+	for i, d := range c.dialogLog {
+		d.Message = redact(d.Message)
+		d.Text = redact(d.Text)
+		dialogs[i] = d
+	}
+	return dialogs
+}
+
+// formatDialog renders a dialog as `confirm "Delete this item?" -> accept`
+func formatDialog(d dialogRecord) string {
+	line := fmt.Sprintf("%s %q -> %s", d.Type, d.Message, d.Action)
+	if d.Text != "" {
+		line += fmt.Sprintf(" with %q", d.Text)
+	}
+	return line
+}
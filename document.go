@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tebeka/selenium"
+)
+
+// keyHeaders are the main-document response headers shown in the output header
+var keyHeaders = []string{"Content-Type", "Content-Length", "Content-Language", "Last-Modified", "ETag", "Cache-Control", "Server"}
+
+// documentInfo describes the HTTP response for the main document, including any redirects
+type documentInfo struct {
+	Status     int
+	StatusText string
+	Headers    http.Header
+	Redirects  []redirectHop
+	FinalURL   string
+}
+
+// redirectHop is one redirect response on the way to the main document
+type redirectHop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// headerLines formats the status, redirect chain and key headers for the output header
+func (info *documentInfo) headerLines() []string {
+	lines := []string{fmt.Sprintf("Status: %d %s", info.Status, info.StatusText)}
+
+	if len(info.Redirects) > 0 {
+		var chain []string
+		for _, hop := range info.Redirects {
+			chain = append(chain, fmt.Sprintf("%s (%d)", hop.URL, hop.Status))
+		}
+		chain = append(chain, info.FinalURL)
+		lines = append(lines, "Redirects: "+strings.Join(chain, " -> "))
+	}
+
+	for _, name := range keyHeaders {
+		if value := info.Headers.Get(name); value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+	return lines
+}
+
+// DocumentInfo returns the response and redirect chain of the main document loaded from
+// startURL, as the browser itself received it. BiDi network events are used when available,
+// then the HAR recording; without either only the status from the page's navigation timing
+// entry is known. The document is never requested a second time.
+func (s *browserSession) DocumentInfo(startURL string) *documentInfo {
+	if s.events != nil {
+		s.events.Flush()
+		return s.events.DocumentInfo(startURL)
+	}
+	if s.recorder != nil {
+		if info := s.recorder.DocumentInfo(startURL); info != nil {
+			return info
+		}
+	}
+	return navigationTimingInfo(s.wd)
+}
+
+// DocumentInfo follows the recorded redirect chain starting at startURL
+func (rec *harRecorder) DocumentInfo(startURL string) *documentInfo {
+	rec.mu.Lock()
+	entries := append([]harEntry{}, rec.entries...)
+	rec.mu.Unlock()
+
+	info := &documentInfo{}
+	current := startURL
+	for hops := 0; hops <= 20; hops++ {
+		var entry *harEntry
+		for i := range entries {
+			if sameURL(entries[i].Request.URL, current) && entries[i].Request.Method == http.MethodGet {
+				entry = &entries[i]
+			}
+		}
+		if entry == nil {
+			return nil
+		}
+
+		if entry.Response.Status >= 300 && entry.Response.Status < 400 && entry.Response.RedirectURL != "" {
+			info.Redirects = append(info.Redirects, redirectHop{URL: current, Status: entry.Response.Status})
+			base, err := url.Parse(current)
+			if err != nil {
+				return nil
+			}
+			next, err := base.Parse(entry.Response.RedirectURL)
+			if err != nil {
+				return nil
+			}
+			current = next.String()
+			continue
+		}
+
+		info.Status = entry.Response.Status
+		info.StatusText = entry.Response.StatusText
+		info.Headers = http.Header{}
+		for _, header := range entry.Response.Headers {
+			info.Headers.Add(header.Name, header.Value)
+		}
+		info.FinalURL = current
+		return info
+	}
+	return nil
+}
+
+// navigationTimingInfo reads the main document's status from its PerformanceNavigationTiming
+// entry, which carries no headers or redirect URLs
+func navigationTimingInfo(wd selenium.WebDriver) *documentInfo {
+	result, err := wd.ExecuteScript(`
+		var nav = performance.getEntriesByType('navigation')[0];
+		return nav && nav.responseStatus ? [nav.responseStatus, location.href] : null;
+	`, nil)
+	values, ok := result.([]interface{})
+	if err != nil || !ok || len(values) != 2 {
+		return nil
+	}
+	status, _ := values[0].(float64)
+	finalURL, _ := values[1].(string)
+	return &documentInfo{
+		Status:     int(status),
+		StatusText: http.StatusText(int(status)),
+		Headers:    http.Header{},
+		FinalURL:   finalURL,
+	}
+}
+
+// sameURL compares two URLs after canonicalURL, so scheme and host case, a default port
+// or an empty path don't make the URL the browser reports differ from the one requested
+func sameURL(a, b string) bool {
+	return normalizeURL(a) == normalizeURL(b)
+}
+
+func normalizeURL(raw string) string {
+	canonical, err := canonicalURL(raw)
+	if err != nil {
+		return raw
+	}
+	return canonical.String()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// downloadStartWait is how long to wait for a download to appear after a click, form
+// submission or script
+const downloadStartWait = 2 * time.Second
+
+// downloadedFile is a file the browser saved into --download-dir during the run
+type downloadedFile struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	MIMEType string `json:"mime_type"`
+}
+
+// downloadPrefs makes Firefox save every download straight into dir without asking,
+// whatever its MIME type, including PDFs, which it would otherwise open in its viewer
+func downloadPrefs(dir string) map[string]interface{} {
+	return map[string]interface{}{
+		"browser.download.folderList":                           2,
+		"browser.download.dir":                                  dir,
+		"browser.download.useDownloadDir":                       true,
+		"browser.download.start_downloads_in_tmp_dir":           false,
+		"browser.download.always_ask_before_handling_new_types": false,
+		"browser.download.manager.showWhenStarting":             false,
+		"browser.download.alwaysOpenPanel":                      false,
+		"pdfjs.disabled":                                        true,
+	}
+}
+
+// downloadWatcher finds the files added to the download directory since it was created
+type downloadWatcher struct {
+	dir      string
+	existing map[string]bool
+}
+
+// watchDownloads records the files already in dir, so only new downloads are reported
+func watchDownloads(dir string) (*downloadWatcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create download directory: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read download directory: %v", err)
+	}
+	w := &downloadWatcher{dir: dir, existing: map[string]bool{}}
+	for _, entry := range entries {
+		w.existing[entry.Name()] = true
+	}
+	return w, nil
+}
+
+// Wait waits for every in-progress (.part) file to finish, then returns the new files. When
+// expectStart is set it first gives a download up to downloadStartWait to appear; otherwise
+// only downloads already under way are collected. It gives up on unfinished downloads after timeout.
+func (w *downloadWatcher) Wait(expectStart bool, timeout time.Duration) []downloadedFile {
+	start := time.Now()
+	var last map[string]int64
+	for {
+		files, partial := w.scan()
+		elapsed := time.Since(start)
+		switch {
+		case partial > 0:
+			if elapsed >= timeout {
+				statusf("Warning: %d download(s) did not finish within %s", partial, timeout)
+				return w.report(files)
+			}
+		case len(files) > 0:
+			// Finished once no .part file is left and sizes have settled
+			if sameSizes(files, last) {
+				return w.report(files)
+			}
+		case !expectStart || elapsed >= downloadStartWait:
+			return nil
+		}
+		last = files
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// scan returns the sizes of new complete files and the number of in-progress downloads
+func (w *downloadWatcher) scan() (map[string]int64, int) {
+	files := map[string]int64{}
+	partial := 0
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return files, 0
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if w.existing[name] || entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, ".part") {
+			partial++
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files[name] = info.Size()
+		}
+	}
+	// Firefox creates an empty placeholder under the final name while the .part file fills
+	if partial > 0 {
+		for name := range files {
+			if _, err := os.Stat(filepath.Join(w.dir, name+".part")); err == nil {
+				delete(files, name)
+			}
+		}
+	}
+	return files, partial
+}
+
+func (w *downloadWatcher) report(files map[string]int64) []downloadedFile {
+	var downloads []downloadedFile
+	for name, size := range files {
+		path := filepath.Join(w.dir, name)
+		downloads = append(downloads, downloadedFile{Name: name, Path: path, Size: size, MIMEType: detectMIMEType(path)})
+		statusf("Downloaded %s (%d bytes)", name, size)
+	}
+	sort.Slice(downloads, func(i, j int) bool { return downloads[i].Name < downloads[j].Name })
+	return downloads
+}
+
+func sameSizes(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, size := range a {
+		if other, ok := b[name]; !ok || other != size {
+			return false
+		}
+	}
+	return true
+}
+
+// detectMIMEType guesses a file's type from its extension, or else from its content
+func detectMIMEType(path string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, _ := file.Read(head)
+	return http.DetectContentType(head[:n])
+}
+
+// formatDownloads renders the DOWNLOADS section
+func formatDownloads(downloads []downloadedFile) string {
+	if len(downloads) == 0 {
+		return ""
+	}
+	result := "\n\n" + strings.Repeat("=", 50) + "\nDOWNLOADS:\n" + strings.Repeat("=", 50) + "\n"
+	for _, d := range downloads {
+		result += fmt.Sprintf("%s (%d bytes, %s) %s\n", d.Name, d.Size, d.MIMEType, d.Path)
+	}
+	return result
+}
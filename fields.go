@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tebeka/selenium"
+)
+
+// fieldsScript describes the fields matching a selector: the first field's element and
+// type, and for checkbox and radio groups each member's value and checked state
+const fieldsScript = queryAllScript + `
+	var fields = webQueryAll(arguments[0], arguments[1]);
+	if (!fields.length) return null;
+	var el = fields[0];
+	return {
+		tag: el.tagName.toLowerCase(),
+		type: (el.getAttribute('type') || '').toLowerCase(),
+		editable: el.isContentEditable,
+		choices: fields.map(function(f) { return {value: f.value || '', checked: !!f.checked}; })
+	};
+`
+
+// setCheckedScript clicks a checkbox or radio if it isn't in the wanted state, which fires
+// the same input and change events as a user click, even for visually hidden inputs
+const setCheckedScript = queryAllScript + `
+	var el = webQueryAll(arguments[0], arguments[1])[arguments[2]];
+	if (el.checked !== arguments[3]) el.click();
+	return el.checked === arguments[3];
+`
+
+// setValueScript assigns an input's value directly, for types that can't be typed into
+// reliably (date, color, range, hidden, ...), and returns the value the input accepted
+const setValueScript = queryAllScript + `
+	var el = webQueryAll(arguments[0], arguments[1])[0];
+	el.value = arguments[2];
+	el.dispatchEvent(new Event('input', { bubbles: true }));
+	el.dispatchEvent(new Event('change', { bubbles: true }));
+	return el.value;
+`
+
+// valueInputTypes are input types whose value is assigned rather than typed
+var valueInputTypes = map[string]bool{
+	"date": true, "time": true, "datetime-local": true, "month": true, "week": true,
+	"color": true, "range": true, "hidden": true,
+}
+
+type fieldChoice struct {
+	Value   string `json:"value"`
+	Checked bool   `json:"checked"`
+}
+
+type fieldInfo struct {
+	Tag      string        `json:"tag"`
+	Type     string        `json:"type"`
+	Editable bool          `json:"editable"`
+	Choices  []fieldChoice `json:"choices"`
+}
+
+// fillField sets the form field matching selector according to its type: selects choose
+// an option by value or label, checkboxes and radios are set to true/false or to the
+// member with the given value, and text inputs, textareas and contenteditable regions
+// are cleared and typed into
+func fillField(wd selenium.WebDriver, selector, value string) error {
+	raw, err := wd.ExecuteScriptRaw(fieldsScript, []interface{}{selector, shadowDOMMode})
+	if err != nil {
+		return fmt.Errorf("could not inspect %s: %v", selector, err)
+	}
+	var reply struct {
+		Value *fieldInfo `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return fmt.Errorf("could not inspect %s: %v", selector, err)
+	}
+	field := reply.Value
+	if field == nil {
+		return fmt.Errorf("could not find %s", selector)
+	}
+
+	switch {
+	case field.Tag == "select":
+		return selectOption(wd, selector, value)
+	case field.Tag == "input" && (field.Type == "checkbox" || field.Type == "radio"):
+		return setChoice(wd, selector, field, value)
+	case field.Tag == "input" && field.Type == "file":
+		return fmt.Errorf("%s is a file input, use --upload", selector)
+	case field.Tag == "input" && valueInputTypes[field.Type]:
+		result, err := wd.ExecuteScript(setValueScript, []interface{}{selector, shadowDOMMode, value})
+		if err != nil {
+			return fmt.Errorf("could not set %s: %v", selector, err)
+		}
+		if accepted, _ := result.(string); accepted != value {
+			return fmt.Errorf("%s input %s did not accept %q", field.Type, selector, value)
+		}
+		return nil
+	case field.Tag != "input" && field.Tag != "textarea" && !field.Editable:
+		return fmt.Errorf("%s is a <%s>, not a form field", selector, field.Tag)
+	}
+
+	elem, err := findElement(wd, selector)
+	if err != nil {
+		return fmt.Errorf("could not find %s: %v", selector, err)
+	}
+	if err := elem.Clear(); err != nil {
+		return fmt.Errorf("could not clear %s: %v", selector, err)
+	}
+	if err := elem.SendKeys(value); err != nil {
+		return fmt.Errorf("could not fill %s: %v", selector, err)
+	}
+	return nil
+}
+
+// setChoice checks the checkbox or radio whose value matches, or sets a single checkbox
+// (or radio) to a true/false value
+func setChoice(wd selenium.WebDriver, selector string, field *fieldInfo, value string) error {
+	index, checked := -1, true
+	for i, choice := range field.Choices {
+		if choice.Value == value {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		state, ok := parseCheckedValue(value)
+		switch {
+		case !ok || len(field.Choices) > 1:
+			var values []string
+			for _, choice := range field.Choices {
+				values = append(values, fmt.Sprintf("%q", choice.Value))
+			}
+			if len(field.Choices) == 1 {
+				return fmt.Errorf("no %s %q for %s (use true/false, or its value %s)", field.Type, value, selector, values[0])
+			}
+			return fmt.Errorf("no %s %q for %s (values: %s)", field.Type, value, selector, strings.Join(values, ", "))
+		case field.Type == "radio" && !state:
+			return fmt.Errorf("radio %s can't be unchecked, give the value of another option", selector)
+		}
+		index, checked = 0, state
+	}
+
+	result, err := wd.ExecuteScript(setCheckedScript, []interface{}{selector, shadowDOMMode, index, checked})
+	if err != nil {
+		return fmt.Errorf("could not set %s: %v", selector, err)
+	}
+	if result != true {
+		return fmt.Errorf("%s %s did not change state (disabled, or prevented by the page)", field.Type, selector)
+	}
+	return nil
+}
+
+// parseCheckedValue reads a checkbox state given as true/false, yes/no, on/off or 1/0
+func parseCheckedValue(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1", "checked":
+		return true, true
+	case "false", "no", "off", "0", "unchecked":
+		return false, true
+	}
+	return false, false
+}
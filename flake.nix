@@ -26,7 +26,7 @@
           version = "0.1.0";
           src = ./.;
 
-          vendorHash = "sha256-Pc/ZMqesG0bNPAqBnbIkRbJfAmz6NW0JvcQ2kwWpG6M=";
+          vendorHash = "sha256-2sfn2s9biu4fruPTRrrGkH8IzV8IDLI7iGWC5HpT0iw=";
 
           nativeBuildInputs = [ pkgs.makeWrapper ];
 
@@ -36,7 +36,7 @@
 
           postInstall = ''
             wrapProgram $out/bin/web \
-              --prefix PATH : ${pkgs.lib.makeBinPath [ firefoxWrapper pkgs.geckodriver ]}
+              --prefix PATH : ${pkgs.lib.makeBinPath [ firefoxWrapper pkgs.geckodriver pkgs.nss.tools ]}
           '';
 
           meta = with pkgs.lib; {
@@ -51,6 +51,7 @@
             pkgs.go
             firefoxWrapper
             pkgs.geckodriver
+            pkgs.nss.tools
           ];
 
           shellHook = ''
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tebeka/selenium"
+	"gopkg.in/yaml.v3"
+)
+
+// flowFile is a declarative session script run with `web run <file>`
+type flowFile struct {
+	Steps []flowStep `yaml:"steps"`
+}
+
+// flowStep is one step of a flow; exactly one action field is set
+type flowStep struct {
+	Goto       *string        `yaml:"goto"`
+	Fill       *flowFill      `yaml:"fill"`
+	Click      *string        `yaml:"click"`
+	Select     *flowFill      `yaml:"select"`
+	Wait       *flowCondition `yaml:"wait"`
+	JS         *string        `yaml:"js"`
+	Screenshot *string        `yaml:"screenshot"`
+	Extract    *flowExtract   `yaml:"extract"`
+	Assert     *flowCondition `yaml:"assert"`
+}
+
+// flowFill sets the value of an input (fill) or chooses an option of a <select> (select)
+type flowFill struct {
+	Selector string `yaml:"selector"`
+	Value    string `yaml:"value"`
+}
+
+// flowCondition is a wait or assert condition; a plain string is a selector
+type flowCondition struct {
+	Selector string `yaml:"selector"`
+	Text     string `yaml:"text"`
+	JS       string `yaml:"js"`
+	Gone     string `yaml:"gone"`
+	URL      string `yaml:"url"`
+}
+
+// flowExtract reads text (or an attribute) from matching elements, or the whole page as
+// markdown when no selector is given; a plain string is a selector
+type flowExtract struct {
+	Selector  string `yaml:"selector"`
+	Attribute string `yaml:"attribute"`
+	Name      string `yaml:"name"`
+}
+
+// flowStepResult is the reported outcome of one step
+type flowStepResult struct {
+	Step     int    `json:"step"`
+	Action   string `json:"action"`
+	Target   string `json:"target,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Duration int64  `json:"duration_ms"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// flowJSONResult is the structured output of `web run --json`
+type flowJSONResult struct {
+	Flow          string           `json:"flow"`
+	OK            bool             `json:"ok"`
+	Steps         []flowStepResult `json:"steps"`
+	Console       []string         `json:"console"`
+	Dialogs       []dialogRecord   `json:"dialogs,omitempty"`
+	Downloads     []downloadedFile `json:"downloads,omitempty"`
+	NetworkErrors []networkError   `json:"network_errors"`
+	Errors        []pageError      `json:"errors"`
+}
+
+func (c *flowCondition) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Selector = node.Value
+		return nil
+	}
+	type plain flowCondition
+	return node.Decode((*plain)(c))
+}
+
+func (e *flowExtract) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Selector = node.Value
+		return nil
+	}
+	type plain flowExtract
+	return node.Decode((*plain)(e))
+}
+
+// waitCondition converts the condition to the WaitCondition used by --wait-*
+func (c *flowCondition) waitCondition() (WaitCondition, error) {
+	var conditions []WaitCondition
+	for _, candidate := range []WaitCondition{
+		{"selector", c.Selector}, {"text", c.Text}, {"js", c.JS}, {"gone", c.Gone}, {"url", c.URL},
+	} {
+		if candidate.Value != "" {
+			conditions = append(conditions, candidate)
+		}
+	}
+	if len(conditions) != 1 {
+		return WaitCondition{}, fmt.Errorf("expected exactly one of selector, text, js, gone or url")
+	}
+	return conditions[0], nil
+}
+
+// action returns the name and target of the step's action
+func (s *flowStep) action() (string, string, error) {
+	var actions []string
+	var target string
+	if s.Goto != nil {
+		actions, target = append(actions, "goto"), *s.Goto
+	}
+	if s.Fill != nil {
+		actions, target = append(actions, "fill"), s.Fill.Selector
+	}
+	if s.Click != nil {
+		actions, target = append(actions, "click"), *s.Click
+	}
+	if s.Select != nil {
+		actions, target = append(actions, "select"), fmt.Sprintf("%s = %s", s.Select.Selector, s.Select.Value)
+	}
+	if s.Wait != nil {
+		condition, err := s.Wait.waitCondition()
+		if err != nil {
+			return "", "", fmt.Errorf("wait: %v", err)
+		}
+		description, _ := conditionScript(condition)
+		actions, target = append(actions, "wait"), description
+	}
+	if s.JS != nil {
+		actions, target = append(actions, "js"), *s.JS
+	}
+	if s.Screenshot != nil {
+		actions, target = append(actions, "screenshot"), *s.Screenshot
+	}
+	if s.Extract != nil {
+		actions, target = append(actions, "extract"), s.Extract.Selector
+	}
+	if s.Assert != nil {
+		condition, err := s.Assert.waitCondition()
+		if err != nil {
+			return "", "", fmt.Errorf("assert: %v", err)
+		}
+		description, _ := conditionScript(condition)
+		actions, target = append(actions, "assert"), description
+	}
+	if len(actions) != 1 {
+		return "", "", fmt.Errorf("expected exactly one of goto, fill, click, select, wait, js, screenshot, extract or assert")
+	}
+	return actions[0], target, nil
+}
+
+// envReference matches ${VAR} references interpolated from the environment
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolateEnv replaces ${VAR} references with environment variables. Values
+// interpolated into secret fields (fill values) are redacted from all output.
+func interpolateEnv(s *string, secret bool) error {
+	var missing []string
+	*s = envReference.ReplaceAllStringFunc(*s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			return ref
+		}
+		if secret {
+			addSecret(value)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// loadFlow reads and validates a flow file, interpolating environment variables
+func loadFlow(path string) (*flowFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read flow: %v", err)
+	}
+
+	var flow flowFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&flow); err != nil {
+		return nil, fmt.Errorf("could not parse flow %s: %v", path, err)
+	}
+	if len(flow.Steps) == 0 {
+		return nil, fmt.Errorf("flow %s has no steps", path)
+	}
+
+	for i := range flow.Steps {
+		step := &flow.Steps[i]
+		if _, _, err := step.action(); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+
+		fields := []*string{step.Goto, step.Click, step.JS, step.Screenshot}
+		if step.Fill != nil {
+			fields = append(fields, &step.Fill.Selector)
+		}
+		if step.Select != nil {
+			fields = append(fields, &step.Select.Selector, &step.Select.Value)
+		}
+		for _, condition := range []*flowCondition{step.Wait, step.Assert} {
+			if condition != nil {
+				fields = append(fields, &condition.Selector, &condition.Text, &condition.JS, &condition.Gone, &condition.URL)
+			}
+		}
+		if step.Extract != nil {
+			fields = append(fields, &step.Extract.Selector, &step.Extract.Attribute)
+		}
+		for _, field := range fields {
+			if field == nil {
+				continue
+			}
+			if err := interpolateEnv(field, false); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+		if step.Fill != nil {
+			if err := interpolateEnv(&step.Fill.Value, true); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+	}
+	return &flow, nil
+}
+
+// runFlow executes the steps of a flow file in one browser session, stopping at the
+// first failing step, and reports each step's output and timing
+func runFlow(config Config, flow *flowFile) (string, error) {
+	session, err := startSession(config)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	runner := &flowRunner{config: config, session: session}
+	var results []flowStepResult
+	var failure error
+	for i := range flow.Steps {
+		step := &flow.Steps[i]
+		action, target, _ := step.action()
+		statusf("Step %d/%d: %s %s", i+1, len(flow.Steps), action, redact(target))
+
+		start := time.Now()
+		output, err := runner.run(step)
+		result := flowStepResult{
+			Step:     i + 1,
+			Action:   action,
+			Target:   redact(target),
+			Duration: time.Since(start).Milliseconds(),
+			Output:   redact(output),
+		}
+		if step.Extract != nil {
+			result.Name = step.Extract.Name
+		}
+		if err != nil {
+			result.Error = redact(err.Error())
+			failure = &checkFailedError{fmt.Sprintf("flow stopped at step %d (%s): %v", i+1, action, err)}
+		}
+		results = append(results, result)
+		if err != nil {
+			break
+		}
+	}
+
+	downloads := session.Downloads(config.WaitTimeout)
+	consoleMessages, networkErrors, pageErrors := session.Diagnostics(config)
+	dialogs := session.Dialogs()
+
+	// Save recorded network traffic
+	if err := session.SaveHAR(); err != nil {
+		return "", err
+	}
+
+	if failure == nil && config.FailOnJSError && len(pageErrors) > 0 {
+		failure = &checkFailedError{fmt.Sprintf("page raised %d JavaScript error(s), first: %s", len(pageErrors), pageErrors[0].Message)}
+	}
+
+	if config.JSONFlag {
+		out := flowJSONResult{
+			Flow:          config.FlowPath,
+			OK:            failure == nil,
+			Steps:         results,
+			Console:       consoleMessages,
+			Dialogs:       dialogs,
+			Downloads:     downloads,
+			NetworkErrors: networkErrors,
+			Errors:        pageErrors,
+		}
+		if out.Console == nil {
+			out.Console = []string{}
+		}
+		if out.NetworkErrors == nil {
+			out.NetworkErrors = []networkError{}
+		}
+		if out.Errors == nil {
+			out.Errors = []pageError{}
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("could not encode JSON output: %v", err)
+		}
+		return string(data), failure
+	}
+
+	header := fmt.Sprintf("Flow: %s (%d of %d steps run)", config.FlowPath, len(results), len(flow.Steps))
+	result := fmt.Sprintf("==========================\n%s\n==========================\n", header)
+	for _, r := range results {
+		line := fmt.Sprintf("\n[%d] %s", r.Step, r.Action)
+		if r.Target != "" {
+			line += " " + r.Target
+		}
+		if r.Name != "" {
+			line += " as " + r.Name
+		}
+		line += fmt.Sprintf(" (%s)", (time.Duration(r.Duration) * time.Millisecond).String())
+		if r.Error != "" {
+			line += "\nFAILED: " + r.Error
+		}
+		if r.Output != "" {
+			line += "\n" + r.Output
+		}
+		result += line + "\n"
+	}
+	result += formatDownloads(downloads)
+	result += formatDiagnostics(consoleMessages, dialogs, networkErrors, pageErrors)
+
+	return result, failure
+}
+
+// flowRunner carries page state between the steps of a flow
+type flowRunner struct {
+	config     Config
+	session    *browserSession
+	isLiveView bool
+}
+
+// run executes one step and returns its output
+func (r *flowRunner) run(step *flowStep) (string, error) {
+	wd := r.session.wd
+	switch {
+	case step.Goto != nil:
+		target, err := r.resolveURL(*step.Goto)
+		if err != nil {
+			return "", err
+		}
+		if err := wd.Get(target); err != nil {
+			return "", fmt.Errorf("could not navigate to %s: %v", target, err)
+		}
+		r.isLiveView = detectLiveView(wd)
+		if r.config.WaitUntil == "networkidle" {
+			waitForNetworkIdle(wd, r.session.events, r.config.IdleTime, r.config.WaitTimeout)
+		}
+		if info := r.session.DocumentInfo(target); info != nil {
+			return info.headerLines()[0], nil
+		}
+		return "", nil
+
+	case step.Fill != nil:
+		return "", fillField(wd, step.Fill.Selector, step.Fill.Value)
+
+	case step.Click != nil:
+		elem, err := findElement(wd, *step.Click)
+		if err != nil {
+			return "", fmt.Errorf("could not find %s: %v", *step.Click, err)
+		}
+		previousURL, _ := wd.CurrentURL()
+		if err := markDocument(wd); err != nil {
+			return "", err
+		}
+		if err := elem.Click(); err != nil {
+			return "", fmt.Errorf("could not click %s: %v", *step.Click, err)
+		}
+		r.session.ExpectDownload()
+		if waitForNavigation(wd, previousURL, r.isLiveView, r.config) {
+			if !r.isLiveView {
+				r.isLiveView = detectLiveView(wd)
+			}
+			currentURL, _ := wd.CurrentURL()
+			return "Navigated to " + currentURL, nil
+		}
+		return "", nil
+
+	case step.Select != nil:
+		return "", selectOption(wd, step.Select.Selector, step.Select.Value)
+
+	case step.Wait != nil:
+		condition, _ := step.Wait.waitCondition()
+		return "", waitForConditions(wd, []WaitCondition{condition}, r.config.WaitTimeout)
+
+	case step.JS != nil:
+		result, err := wd.ExecuteScript(*step.JS, nil)
+		r.session.ExpectDownload()
+		if err != nil {
+			return "", fmt.Errorf("JavaScript execution failed: %v", err)
+		}
+		return formatScriptResult(result), nil
+
+	case step.Screenshot != nil:
+		screenshot, err := wd.Screenshot()
+		if err != nil {
+			return "", fmt.Errorf("error taking screenshot: %v", err)
+		}
+		if err := os.WriteFile(*step.Screenshot, screenshot, 0644); err != nil {
+			return "", fmt.Errorf("error saving screenshot: %v", err)
+		}
+		return "", nil
+
+	case step.Extract != nil:
+		return r.extract(step.Extract)
+
+	case step.Assert != nil:
+		condition, _ := step.Assert.waitCondition()
+		met, err := checkCondition(wd, condition)
+		if err != nil {
+			return "", fmt.Errorf("could not check assertion: %v", err)
+		}
+		if !met {
+			description, _ := conditionScript(condition)
+			return "", fmt.Errorf("assertion failed: expected %s", description)
+		}
+		return "", nil
+	}
+	return "", nil
+}
+
+// extract returns the text or attribute of each matching element, one per line,
+// or the page as markdown when no selector is given
+func (r *flowRunner) extract(extract *flowExtract) (string, error) {
+	if extract.Selector == "" {
+		content, frames, err := capturePage(r.session.wd, r.config.Frames)
+		if err != nil {
+			return "", fmt.Errorf("could not get page content: %v", err)
+		}
+		output, _, err := pageMarkdown(content, frames, r.config.TruncateAfter)
+		return output, err
+	}
+
+	elems, err := findElements(r.session.wd, extract.Selector)
+	if err != nil {
+		return "", fmt.Errorf("could not find %s: %v", extract.Selector, err)
+	}
+	if len(elems) == 0 {
+		return "", fmt.Errorf("no elements match %s", extract.Selector)
+	}
+	var values []string
+	for _, elem := range elems {
+		var value string
+		if extract.Attribute != "" {
+			value, err = elem.GetAttribute(extract.Attribute)
+		} else {
+			value, err = elem.Text()
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %v", extract.Selector, err)
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "\n"), nil
+}
+
+// resolveURL resolves a goto target against the current page, so flows can use paths
+func (r *flowRunner) resolveURL(target string) (string, error) {
+	if !strings.HasPrefix(target, "/") {
+		return ensureProtocol(target), nil
+	}
+	currentURL, err := r.session.wd.CurrentURL()
+	if err != nil {
+		return "", fmt.Errorf("could not get current URL: %v", err)
+	}
+	base, err := url.Parse(currentURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return "", fmt.Errorf("cannot resolve %s before the first absolute goto", target)
+	}
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %v", target, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// selectOption chooses the option of a <select> whose value or label matches value
+func selectOption(wd selenium.WebDriver, selector, value string) error {
+	result, err := wd.ExecuteScript(queryAllScript+`
+		var selector = arguments[0], value = arguments[1];
+		var el = webQueryAll(selector, arguments[2])[0];
+		if (!el) return 'could not find ' + selector;
+		if (el.tagName !== 'SELECT') return selector + ' is not a <select>';
+		var options = Array.prototype.slice.call(el.options);
+		var match = options.filter(function(o) { return o.value === value; })[0] ||
+			options.filter(function(o) { return o.text.trim() === value; })[0];
+		if (!match) {
+			return 'no option ' + JSON.stringify(value) + ' in ' + selector + ' (options: ' +
+				options.map(function(o) { return JSON.stringify(o.value); }).join(', ') + ')';
+		}
+		el.value = match.value;
+		el.dispatchEvent(new Event('input', { bubbles: true }));
+		el.dispatchEvent(new Event('change', { bubbles: true }));
+		return '';
+	`, []interface{}{selector, value, shadowDOMMode})
+	if err != nil {
+		return fmt.Errorf("could not select %s: %v", selector, err)
+	}
+	if message, _ := result.(string); message != "" {
+		return fmt.Errorf("%s", message)
+	}
+	return nil
+}
+
+// formatScriptResult renders a JavaScript return value, leaving strings unquoted
+func formatScriptResult(result interface{}) string {
+	switch v := result.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Sprint(result)
+	}
+	return string(data)
+}
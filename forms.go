@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tebeka/selenium"
+)
+
+// formLocatorScript finds the form for --form-action, or the page's only form when no form
+// option is given. It returns the form's action attribute, or an error message.
+const formLocatorScript = queryAllScript + `
+	var action = arguments[0], forms = webQueryAll('form', arguments[1]);
+	var describe = function(form) {
+		var parts = [];
+		if (form.id) parts.push('id=' + JSON.stringify(form.id));
+		if (form.getAttribute('name')) parts.push('name=' + JSON.stringify(form.getAttribute('name')));
+		if (form.getAttribute('action')) parts.push('action=' + JSON.stringify(form.getAttribute('action')));
+		return parts.length ? parts.join(' ') : '(no id, name or action)';
+	};
+	if (action === null) {
+		if (forms.length === 1) return {found: true};
+		if (forms.length === 0) return {error: 'no form on the page'};
+		return {error: 'the page has ' + forms.length + ' forms, choose one with --form, --form-name, ' +
+			'--form-action or --form-selector: ' + forms.map(describe).join('; ')};
+	}
+	var target = new URL(action, location.href);
+	for (var i = 0; i < forms.length; i++) {
+		var form = forms[i], formURL = new URL(form.getAttribute('action') || '', location.href);
+		var samePath = formURL.origin === target.origin && formURL.pathname === target.pathname;
+		if (samePath && (target.search === '' || formURL.search === target.search)) {
+			return {found: true, action: form.getAttribute('action') || ''};
+		}
+	}
+	return {error: 'no form with action ' + action + (forms.length ? ' (forms: ' + forms.map(describe).join('; ') + ')' : '')};
+`
+
+// locateForm returns a CSS selector for the form chosen by --form, --form-selector,
+// --form-name or --form-action, or for the page's only form
+func locateForm(wd selenium.WebDriver, config Config) (string, error) {
+	var selector string
+	switch {
+	case config.FormSelector != "":
+		selector = config.FormSelector
+	case config.FormID != "":
+		selector = "#" + cssIdent(config.FormID)
+	case config.FormName != "":
+		selector = "form[name=" + cssString(config.FormName) + "]"
+	}
+	if selector != "" {
+		if elems, _ := findElements(wd, selector); len(elems) == 0 {
+			return "", fmt.Errorf("no %s on the page", describeForm(config))
+		}
+		return selector, nil
+	}
+
+	var action interface{}
+	if config.FormAction != "" {
+		action = config.FormAction
+	}
+	raw, err := wd.ExecuteScriptRaw(formLocatorScript, []interface{}{action, shadowDOMMode})
+	if err != nil {
+		return "", fmt.Errorf("could not look for forms: %v", err)
+	}
+	var reply struct {
+		Value struct {
+			Found  bool   `json:"found"`
+			Action string `json:"action"`
+			Error  string `json:"error"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return "", fmt.Errorf("could not look for forms: %v", err)
+	}
+	if !reply.Value.Found {
+		return "", fmt.Errorf("%s", reply.Value.Error)
+	}
+	if config.FormAction == "" {
+		statusf("Using the only form on the page")
+		return "form", nil
+	}
+	if reply.Value.Action == "" {
+		// A form without an action submits to the page itself
+		return `form:not([action]), form[action=""]`, nil
+	}
+	return "form[action=" + cssString(reply.Value.Action) + "]", nil
+}
+
+// describeForm names the form option that was used to choose the form, for error messages
+func describeForm(config Config) string {
+	switch {
+	case config.FormSelector != "":
+		return fmt.Sprintf("form matching --form-selector %q", config.FormSelector)
+	case config.FormID != "":
+		return fmt.Sprintf("form with id %q", config.FormID)
+	case config.FormName != "":
+		return fmt.Sprintf("form named %q", config.FormName)
+	case config.FormAction != "":
+		return fmt.Sprintf("form with action %q", config.FormAction)
+	}
+	return "page's only form"
+}
+
+// fieldSelector returns the selector for an --input field within form: the field with that
+// name, or, for fields without a name such as contenteditable editors, the identifier itself
+// when it is written as a CSS selector
+func fieldSelector(wd selenium.WebDriver, form string, name string, config Config) (string, error) {
+	selector := within(form, "[name="+cssString(name)+"]")
+	if elems, _ := findElements(wd, selector); len(elems) > 0 {
+		return selector, nil
+	}
+	if !looksLikeSelector(name) {
+		return "", fmt.Errorf("no field named %q in the %s (to target a field without a name, give a CSS selector such as #id, .class or [attr])", name, describeForm(config))
+	}
+	selector = within(form, name)
+	if elems, _ := findElements(wd, selector); len(elems) == 0 {
+		return "", fmt.Errorf("no field named %q or matching it as a selector in the %s", name, describeForm(config))
+	}
+	return selector, nil
+}
+
+// looksLikeSelector reports whether an identifier is written as a CSS selector rather than
+// a name: it starts with #, . or [, or contains a combinator
+func looksLikeSelector(s string) bool {
+	return strings.HasPrefix(s, "#") || strings.HasPrefix(s, ".") || strings.HasPrefix(s, "[") ||
+		strings.ContainsAny(strings.TrimSpace(s), " >+~")
+}
+
+// within scopes selector to descendants of the elements matching scope
+func within(scope, selector string) string {
+	if strings.Contains(scope, ",") {
+		scope = ":is(" + scope + ")"
+	}
+	return scope + " " + selector
+}
+
+// cssIdent escapes s for use as a CSS identifier, e.g. after # in an id selector,
+// following CSS.escape() from the CSSOM specification
+func cssIdent(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == 0:
+			b.WriteRune('\uFFFD')
+		case (r >= 0x01 && r <= 0x1F) || r == 0x7F,
+			i == 0 && r >= '0' && r <= '9',
+			i == 1 && r >= '0' && r <= '9' && runes[0] == '-':
+			fmt.Fprintf(&b, "\\%x ", r)
+		case i == 0 && r == '-' && len(runes) == 1:
+			b.WriteString("\\-")
+		case r >= 0x80 || r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// cssString quotes s as a CSS string, for attribute selectors like [name="user[email]"]
+func cssString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == 0:
+			b.WriteRune('\uFFFD')
+		case r == '"' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case (r >= 0x01 && r <= 0x1F) || r == 0x7F:
+			fmt.Fprintf(&b, "\\%x ", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tebeka/selenium"
+)
+
+const DEFAULT_FRAME_DEPTH = 3
+
+// frameOptions controls how iframe content is inlined into the converted output
+type frameOptions struct {
+	MaxDepth    int  // levels of nested frames to inline, 0 to leave iframes out
+	CrossOrigin bool // also inline frames from other origins (ads, widgets)
+}
+
+// frameInfo describes an iframe as seen from its parent document
+type frameInfo struct {
+	Src   string `json:"src"`
+	Label string `json:"label"`
+}
+
+// markFramesScript tags each iframe in the current document with its index, so it can be
+// found again to switch into and replaced by its placeholder when serializing
+const markFramesScript = `
+	return Array.prototype.map.call(document.querySelectorAll('iframe'), function(frame, i) {
+		frame.setAttribute('data-web-frame', i);
+		return {
+			src: frame.getAttribute('srcdoc') !== null ? 'srcdoc' : (frame.src || 'about:blank'),
+			label: frame.title || frame.name || frame.id || ''
+		};
+	});
+`
+
+// framePlaceholdersScript serializes a copy of the document in which each iframe listed in
+// the argument (data-web-frame index to placeholder) is replaced by a paragraph holding its
+// placeholder; the live page, and the frames' loaded state, are left untouched
+const framePlaceholdersScript = `
+	var keys = arguments[0];
+	var copy = document.documentElement.cloneNode(true);
+	Array.prototype.forEach.call(copy.querySelectorAll('iframe[data-web-frame]'), function(frame) {
+		var key = keys[frame.getAttribute('data-web-frame')];
+		if (!key) return;
+		var placeholder = document.createElement('p');
+		placeholder.textContent = key;
+		frame.parentNode.replaceChild(placeholder, frame);
+	});
+	return '<!DOCTYPE html>' + copy.outerHTML;
+`
+
+// capturePage returns the current page HTML. Unless inlining is disabled, each iframe is
+// replaced by a placeholder and the converted content of each frame, with its own frames
+// inlined, is returned keyed by placeholder for pageMarkdown to substitute.
+func capturePage(wd selenium.WebDriver, opts frameOptions) (string, map[string]string, error) {
+	if opts.MaxDepth == 0 {
+		content, err := pageSource(wd)
+		return content, nil, err
+	}
+
+	origin, err := wd.ExecuteScript("return location.origin", nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read page origin: %v", err)
+	}
+	frames := map[string]string{}
+	content, err := captureFrame(wd, nil, opts, fmt.Sprint(origin), frames)
+	// Always leave the driver on the top-level document
+	wd.SwitchFrame(nil)
+	return content, frames, err
+}
+
+// captureFrame serializes the document at path (already switched to) and captures its iframes
+func captureFrame(wd selenium.WebDriver, path []int, opts frameOptions, topOrigin string, frames map[string]string) (string, error) {
+	var infos []frameInfo
+	raw, err := wd.ExecuteScriptRaw(markFramesScript, nil)
+	if err == nil {
+		var reply struct {
+			Value []frameInfo `json:"value"`
+		}
+		err = json.Unmarshal(raw, &reply)
+		infos = reply.Value
+	}
+	if err != nil {
+		statusf("Warning: Could not list iframes: %v", err)
+	}
+
+	keys := map[string]string{}
+	for i := range infos {
+		keys[strconv.Itoa(i)] = framePlaceholder(append(path[:len(path):len(path)], i))
+	}
+	content, err := framePageSource(wd, keys)
+	if err != nil {
+		return "", fmt.Errorf("could not get page content: %v", err)
+	}
+
+	for i, info := range infos {
+		childPath := append(path[:len(path):len(path)], i)
+		frames[keys[strconv.Itoa(i)]] = captureChildFrame(wd, childPath, info, opts, topOrigin, frames)
+	}
+	return content, nil
+}
+
+// framePageSource returns the current document's HTML with the marked iframes replaced by
+// placeholders, flattening shadow roots in shadow DOM mode
+func framePageSource(wd selenium.WebDriver, keys map[string]string) (string, error) {
+	script := framePlaceholdersScript
+	if shadowDOMMode {
+		script = flattenShadowScript
+	}
+	result, err := wd.ExecuteScript(script, []interface{}{keys})
+	if err != nil {
+		return "", err
+	}
+	content, ok := result.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected result %T", result)
+	}
+	return content, nil
+}
+
+// captureChildFrame returns the labelled markdown for the frame at path
+func captureChildFrame(wd selenium.WebDriver, path []int, info frameInfo, opts frameOptions, topOrigin string, frames map[string]string) string {
+	label := info.Src
+	if info.Label != "" {
+		label = fmt.Sprintf("%q %s", info.Label, info.Src)
+	}
+	boundary := func(body string) string {
+		return fmt.Sprintf("--- iframe %s ---\n%s\n--- end iframe ---", label, strings.TrimSpace(body))
+	}
+
+	if len(path) > opts.MaxDepth {
+		return boundary("(not included: nested deeper than --frame-depth)")
+	}
+	if err := switchToFramePath(wd, path); err != nil {
+		return boundary(fmt.Sprintf("(not included: %v)", err))
+	}
+	origin, err := wd.ExecuteScript("return location.origin", nil)
+	if err != nil {
+		return boundary(fmt.Sprintf("(not included: %v)", err))
+	}
+	// srcdoc and about:blank frames belong to their parent, even when sandboxed to a "null" origin
+	inherited := info.Src == "srcdoc" || strings.HasPrefix(info.Src, "about:")
+	if fmt.Sprint(origin) != topOrigin && !inherited && !opts.CrossOrigin {
+		return boundary("(cross-origin, not included; use --cross-origin-frames)")
+	}
+
+	content, err := captureFrame(wd, path, opts, topOrigin, frames)
+	if err != nil {
+		return boundary(fmt.Sprintf("(not included: %v)", err))
+	}
+	text, err := htmlToMarkdown(content)
+	if err != nil {
+		return boundary(fmt.Sprintf("(not included: %v)", err))
+	}
+	if strings.TrimSpace(text) == "" {
+		text = "(empty)"
+	}
+	return boundary(substituteFrames(text, frames))
+}
+
+// switchToFramePath switches from the top-level document into the frame at path, using the
+// indexes assigned by markFramesScript at each level
+func switchToFramePath(wd selenium.WebDriver, path []int) error {
+	if err := wd.SwitchFrame(nil); err != nil {
+		return err
+	}
+	for _, index := range path {
+		elem, err := wd.FindElement(selenium.ByCSSSelector, fmt.Sprintf(`iframe[data-web-frame="%d"]`, index))
+		if err != nil {
+			return fmt.Errorf("frame disappeared: %v", err)
+		}
+		if err := wd.SwitchFrame(elem); err != nil {
+			return fmt.Errorf("could not enter frame: %v", err)
+		}
+	}
+	return nil
+}
+
+// switchToFrame switches into the frame for a --frame selector; nested frames are
+// separated by ">>", e.g. "#outer >> iframe.editor"
+func switchToFrame(wd selenium.WebDriver, selectors string) error {
+	if err := wd.SwitchFrame(nil); err != nil {
+		return err
+	}
+	for _, selector := range strings.Split(selectors, ">>") {
+		selector = strings.TrimSpace(selector)
+		elem, err := findElement(wd, selector)
+		if err != nil {
+			return fmt.Errorf("could not find frame %s: %v", selector, err)
+		}
+		if err := wd.SwitchFrame(elem); err != nil {
+			return fmt.Errorf("could not switch to frame %s: %v", selector, err)
+		}
+	}
+	return nil
+}
+
+func framePlaceholder(path []int) string {
+	parts := make([]string, len(path))
+	for i, index := range path {
+		parts[i] = strconv.Itoa(index)
+	}
+	return "WEBFRAME" + strings.Join(parts, "X") + "PLACEHOLDER"
+}
+
+// substituteFrames replaces frame placeholders in converted text with the frames' content
+func substituteFrames(text string, frames map[string]string) string {
+	for key, frame := range frames {
+		text = strings.Replace(text, key, frame, 1)
+	}
+	return text
+}
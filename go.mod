@@ -3,8 +3,10 @@ module web
 go 1.24
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/jaytaylor/html2text v0.0.0-20230321000545-74c2419ad056
 	github.com/tebeka/selenium v0.9.9
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
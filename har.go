@@ -0,0 +1,816 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_HAR_BODY_LIMIT = 1024 * 1024
+
+// harCANickname names the recording CA in the profile's certificate database
+const harCANickname = "web HAR recording CA"
+
+// harRecorder is an in-process HTTP(S) proxy that records every request/response
+// passing through it. HTTPS is intercepted with leaf certificates minted from a
+// local CA kept in the profile directory and trusted by the profile while recording.
+// Upstream certificates are verified as the browser would, against the system roots.
+type harRecorder struct {
+	bodyLimit   int64
+	listener    net.Listener
+	transport   *http.Transport
+	ca          tls.Certificate
+	caCert      *x509.Certificate
+	uninstallCA func()
+
+	mu      sync.Mutex
+	entries []harEntry
+	certs   map[string]*tls.Certificate
+}
+
+// HAR 1.2 structures (http://www.softwareishard.com/blog/har-12-spec/)
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// startHARRecorder starts the recording proxy on a random local port
+func startHARRecorder(profileDir string, bodyLimit int64) (*harRecorder, error) {
+	ca, caCert, err := generateCA()
+	if err != nil {
+		return nil, fmt.Errorf("could not set up HAR certificate authority: %v", err)
+	}
+	uninstallCA, err := installCA(profileDir, caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		uninstallCA()
+		return nil, fmt.Errorf("could not start HAR proxy: %v", err)
+	}
+
+	rec := &harRecorder{
+		bodyLimit:   bodyLimit,
+		listener:    listener,
+		ca:          ca,
+		caCert:      caCert,
+		uninstallCA: uninstallCA,
+		certs:       map[string]*tls.Certificate{},
+		transport: &http.Transport{
+			Proxy:               nil,
+			DisableCompression:  true,
+			MaxIdleConnsPerHost: 8,
+			TLSClientConfig:     &tls.Config{},
+		},
+	}
+
+	go http.Serve(listener, rec)
+	return rec, nil
+}
+
+// Addr returns the host:port the proxy listens on
+func (rec *harRecorder) Addr() string {
+	return rec.listener.Addr().String()
+}
+
+// Close stops accepting new proxy connections and removes the CA from the profile's trust store
+func (rec *harRecorder) Close() {
+	rec.listener.Close()
+	rec.transport.CloseIdleConnections()
+	rec.uninstallCA()
+}
+
+// ServeHTTP handles both plain proxied requests and CONNECT tunnels
+func (rec *harRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		rec.handleConnect(w, r)
+		return
+	}
+	if isWebSocketUpgrade(r) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+			return
+		}
+		clientConn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+		defer clientConn.Close()
+		rec.spliceWebSocket(r, rw.Reader, clientConn)
+		return
+	}
+
+	resp, body, err := rec.roundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(flushWriter{w}, body)
+	body.Close()
+}
+
+// handleConnect serves a CONNECT tunnel. TLS is terminated for the tunnelled host so the
+// decrypted requests can be recorded; a tunnel that doesn't start with a TLS handshake
+// (e.g. a ws:// WebSocket) carries plain HTTP and is read as such.
+func (rec *harRecorder) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+
+	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	host := r.Host
+	first, err := rw.Reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] != tlsHandshakeRecord {
+		rec.serveTunnel(rw.Reader, clientConn, "http", host, r.RemoteAddr)
+		return
+	}
+
+	tlsConn := tls.Server(bufferedConn{clientConn, rw.Reader}, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name, _, _ = net.SplitHostPort(host)
+			}
+			return rec.certificateFor(name)
+		},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	defer tlsConn.Close()
+
+	rec.serveTunnel(bufio.NewReader(tlsConn), tlsConn, "https", host, r.RemoteAddr)
+}
+
+// tlsHandshakeRecord is the first byte of a TLS ClientHello
+const tlsHandshakeRecord = 0x16
+
+// serveTunnel proxies the requests read from a CONNECT tunnel to host until either side
+// closes the connection, handing WebSocket upgrades over to spliceWebSocket
+func (rec *harRecorder) serveTunnel(reader *bufio.Reader, conn net.Conn, scheme, host, remoteAddr string) {
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		req.URL.Scheme = scheme
+		req.URL.Host = host
+		req.RemoteAddr = remoteAddr
+
+		if isWebSocketUpgrade(req) {
+			rec.spliceWebSocket(req, reader, conn)
+			return
+		}
+
+		resp, body, err := rec.roundTrip(req)
+		if err != nil {
+			errResp := &http.Response{
+				StatusCode: http.StatusBadGateway,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				Body:       io.NopCloser(strings.NewReader(err.Error())),
+			}
+			errResp.Write(conn)
+			return
+		}
+		resp.Body = body
+		err = resp.Write(conn)
+		body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// isWebSocketUpgrade reports whether r asks to switch the connection to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// spliceWebSocket forwards a WebSocket handshake upstream unchanged and, once the server
+// switches protocols, copies frames in both directions until either side closes. Only the
+// handshake is recorded; frames pass through untouched.
+func (rec *harRecorder) spliceWebSocket(r *http.Request, clientReader *bufio.Reader, clientConn net.Conn) {
+	started := time.Now()
+	fail := func(err error) {
+		rec.add(harEntry{
+			StartedDateTime: started,
+			Time:            millis(time.Since(started)),
+			Request:         rec.harRequest(r, nil),
+			Response:        harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+			Timings:         harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(time.Since(started))},
+			Comment:         err.Error(),
+		})
+		fmt.Fprintf(clientConn, "HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(err.Error()), err.Error())
+	}
+
+	addr := r.URL.Host
+	if r.URL.Port() == "" {
+		if r.URL.Scheme == "https" || r.URL.Scheme == "wss" {
+			addr = net.JoinHostPort(r.URL.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(r.URL.Hostname(), "80")
+		}
+	}
+	var upstream net.Conn
+	var err error
+	if r.URL.Scheme == "https" || r.URL.Scheme == "wss" {
+		upstream, err = tls.Dial("tcp", addr, &tls.Config{ServerName: r.URL.Hostname(), NextProtos: []string{"http/1.1"}})
+	} else {
+		upstream, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		fail(upstreamError(r.URL.Host, err))
+		return
+	}
+	defer upstream.Close()
+
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del("Proxy-Authorization")
+	if err := r.Write(upstream); err != nil {
+		fail(err)
+		return
+	}
+	upstreamReader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamReader, r)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	serverIP, _, _ := net.SplitHostPort(upstream.RemoteAddr().String())
+	rec.add(harEntry{
+		StartedDateTime: started,
+		Time:            millis(time.Since(started)),
+		Request:         rec.harRequest(r, nil),
+		Response:        rec.harResponse(resp, nil, 0, false),
+		Timings:         harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(time.Since(started))},
+		ServerIPAddress: serverIP,
+		Comment:         "WebSocket handshake; frames are not recorded",
+	})
+
+	// Relay the response as received; re-serializing it would add framing headers
+	fmt.Fprintf(clientConn, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	resp.Header.Write(clientConn)
+	io.WriteString(clientConn, "\r\n")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		io.Copy(clientConn, resp.Body)
+		resp.Body.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, clientReader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, upstreamReader)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// upstreamError explains a failed upstream connection. The browser only sees the proxy's
+// certificate, so a rejected upstream certificate is also reported as a warning here.
+func upstreamError(host string, err error) error {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		err = fmt.Errorf("upstream certificate for %s rejected: %v", host, certErr.Err)
+		statusf("Warning: %v", err)
+	}
+	return err
+}
+
+// bufferedConn is a connection whose reads first drain bytes already buffered from it
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// roundTrip forwards a request upstream and returns the response along with a body
+// reader that records the entry once the browser has finished reading it
+func (rec *harRecorder) roundTrip(r *http.Request) (*http.Response, io.ReadCloser, error) {
+	started := time.Now()
+
+	// Buffer the request body so it can be recorded and replayed upstream
+	var reqBody []byte
+	if r.Body != nil {
+		reqBody, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Body = io.NopCloser(bytes.NewReader(reqBody))
+	out.ContentLength = int64(len(reqBody))
+	for _, header := range []string{"Proxy-Connection", "Proxy-Authorization", "Connection", "Keep-Alive", "Te", "Trailer", "Upgrade"} {
+		out.Header.Del(header)
+	}
+	// Restrict encodings to ones the recorder can decode for the HAR body text
+	if out.Header.Get("Accept-Encoding") != "" {
+		out.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+
+	var timing struct {
+		dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, wrote, firstByte time.Time
+		remoteAddr                                                                        string
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { timing.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { timing.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { timing.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { timing.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { timing.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timing.tlsDone = time.Now() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { timing.wrote = time.Now() },
+		GotFirstResponseByte: func() { timing.firstByte = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn != nil {
+				timing.remoteAddr, _, _ = net.SplitHostPort(info.Conn.RemoteAddr().String())
+			}
+		},
+	}
+	out = out.WithContext(httptrace.WithClientTrace(out.Context(), trace))
+
+	resp, err := rec.transport.RoundTrip(out)
+	if err != nil {
+		err = upstreamError(r.URL.Host, err)
+		rec.add(harEntry{
+			StartedDateTime: started,
+			Time:            millis(time.Since(started)),
+			Request:         rec.harRequest(r, reqBody),
+			Response:        harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+			Timings:         harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(time.Since(started))},
+			Comment:         err.Error(),
+		})
+		return nil, nil, err
+	}
+	headersAt := time.Now()
+
+	body := &recordingBody{
+		ReadCloser: resp.Body,
+		limit:      rec.bodyLimit,
+		onClose: func(captured []byte, size int64, truncated bool) {
+			done := time.Now()
+			timings := harTimings{
+				Blocked: -1,
+				DNS:     span(timing.dnsStart, timing.dnsDone),
+				Connect: span(timing.connectStart, timing.connectDone),
+				SSL:     span(timing.tlsStart, timing.tlsDone),
+				Send:    0,
+				Wait:    millis(headersAt.Sub(started)),
+				Receive: millis(done.Sub(headersAt)),
+			}
+			if !timing.wrote.IsZero() && !timing.firstByte.IsZero() {
+				timings.Wait = millis(timing.firstByte.Sub(timing.wrote))
+			}
+			rec.add(harEntry{
+				StartedDateTime: started,
+				Time:            millis(done.Sub(started)),
+				Request:         rec.harRequest(r, reqBody),
+				Response:        rec.harResponse(resp, captured, size, truncated),
+				Timings:         timings,
+				ServerIPAddress: timing.remoteAddr,
+			})
+		},
+	}
+	return resp, body, nil
+}
+
+func (rec *harRecorder) add(entry harEntry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries = append(rec.entries, entry)
+}
+
+func (rec *harRecorder) harRequest(r *http.Request, body []byte) harRequest {
+	req := harRequest{
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		HTTPVersion: r.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(r.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	for _, cookie := range r.Cookies() {
+		req.Cookies = append(req.Cookies, harNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: value})
+		}
+	}
+	if len(body) > 0 {
+		// Form submissions carry credentials; keep secrets out of the HAR file
+		req.PostData = &harPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     redact(string(body)),
+		}
+	}
+	return req
+}
+
+func (rec *harRecorder) harResponse(resp *http.Response, captured []byte, size int64, truncated bool) harResponse {
+	mimeType := resp.Header.Get("Content-Type")
+	result := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    size,
+		Content: harContent{
+			Size:     size,
+			MimeType: mimeType,
+		},
+	}
+	for _, cookie := range resp.Cookies() {
+		result.Cookies = append(result.Cookies, harNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	if truncated {
+		result.Content.Comment = fmt.Sprintf("body not captured, exceeds %d byte limit", rec.bodyLimit)
+		return result
+	}
+
+	decoded, err := decodeBody(captured, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		result.Content.Comment = fmt.Sprintf("could not decode body: %v", err)
+		return result
+	}
+	result.Content.Size = int64(len(decoded))
+	if isTextMimeType(mimeType) {
+		result.Content.Text = string(decoded)
+	} else if len(decoded) > 0 {
+		result.Content.Text = base64.StdEncoding.EncodeToString(decoded)
+		result.Content.Encoding = "base64"
+	}
+	return result
+}
+
+// WriteFile saves all recorded entries as a HAR 1.2 file
+func (rec *harRecorder) WriteFile(path string) (int, error) {
+	rec.mu.Lock()
+	entries := append([]harEntry{}, rec.entries...)
+	rec.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+
+	var har harLog
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "web", Version: "0.1.0"}
+	har.Log.Entries = entries
+
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), os.WriteFile(path, data, 0644)
+}
+
+// certificateFor returns a leaf certificate for host signed by the recorder's CA
+func (rec *harRecorder) certificateFor(host string) (*tls.Certificate, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if cert, ok := rec.certs[host]; ok {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, rec.caCert, &key.PublicKey, rec.ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, rec.ca.Certificate[0]},
+		PrivateKey:  key,
+	}
+	rec.certs[host] = cert
+	return cert, nil
+}
+
+// installCA adds the recording CA to the profile's NSS certificate database with certutil,
+// so Firefox trusts the proxy's certificates without turning off certificate checks.
+// Any CA left trusted by an earlier run that was killed before removing it is deleted first.
+// The returned function removes the CA again once Firefox has exited.
+func installCA(profileDir string, caCert *x509.Certificate) (func(), error) {
+	certutil, err := exec.LookPath("certutil")
+	if err != nil {
+		return nil, fmt.Errorf("--har needs certutil from the NSS tools to trust its CA in the profile " +
+			"(e.g. apt install libnss3-tools, dnf install nss-tools or brew install nss)")
+	}
+	db := "sql:" + profileDir
+	run := func(stdin []byte, args ...string) error {
+		cmd := exec.Command(certutil, append(args, "-d", db)...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("certutil %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	remove := func() {
+		// Each call deletes one certificate with the nickname; stop once none is left
+		for i := 0; i < 10; i++ {
+			if run(nil, "-D", "-n", harCANickname) != nil {
+				return
+			}
+		}
+	}
+
+	// A profile Firefox has never opened has no certificate database yet
+	if _, err := os.Stat(filepath.Join(profileDir, "cert9.db")); os.IsNotExist(err) {
+		if err := run(nil, "-N", "--empty-password"); err != nil {
+			return nil, fmt.Errorf("could not create the profile's certificate database: %v", err)
+		}
+	}
+	remove()
+	// Earlier versions kept the CA and its key in the profile
+	os.Remove(filepath.Join(profileDir, "web-har-ca-key.pem"))
+	os.Remove(filepath.Join(profileDir, "web-har-ca.pem"))
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if err := run(certPEM, "-A", "-n", harCANickname, "-t", "C,,", "-a"); err != nil {
+		return nil, fmt.Errorf("could not install the HAR CA into the profile: %v", err)
+	}
+	return remove, nil
+}
+
+// generateCA creates the recording CA for one run. Its key is only ever held in memory and
+// it expires after a day, so a run that is killed leaves nothing behind that can sign
+func generateCA() (tls.Certificate, *x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: harCANickname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: caCert}, caCert, nil
+}
+
+// recordingBody passes a response body through to the browser while keeping a copy
+// of up to limit bytes, and reports what it saw when closed
+type recordingBody struct {
+	io.ReadCloser
+	limit     int64
+	buf       bytes.Buffer
+	size      int64
+	truncated bool
+	onClose   func(captured []byte, size int64, truncated bool)
+	once      sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if !b.truncated {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.truncated = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.onClose(b.buf.Bytes(), b.size, b.truncated)
+	})
+	return err
+}
+
+// flushWriter flushes after every write so streamed responses reach the browser promptly
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+func decodeBody(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		if len(body) == 0 {
+			return body, nil
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	case "deflate":
+		return io.ReadAll(flate.NewReader(bytes.NewReader(body)))
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+func isTextMimeType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	for _, marker := range []string{"json", "javascript", "xml", "x-www-form-urlencoded", "svg", "graphql"} {
+		if strings.Contains(mimeType, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// span returns the duration between two trace points in ms, or -1 if the phase didn't happen
+func span(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return millis(end.Sub(start))
+}
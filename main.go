@@ -2,12 +2,15 @@ package main
 
 import (
 	"archive/zip"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -15,11 +18,64 @@ import (
 
 	"github.com/jaytaylor/html2text"
 	"github.com/tebeka/selenium"
-	"github.com/tebeka/selenium/log"
 )
 
 const DEFAULT_TRUNCATE_AFTER = 100000
 
+const DEFAULT_WAIT_TIMEOUT = 10 * time.Second
+
+const DEFAULT_IDLE_TIME = 500 * time.Millisecond
+
+// networkIdleScript tracks in-flight fetch/XHR requests and the most recent network
+// activity (including subresources via PerformanceObserver). It is only used when WebDriver
+// BiDi is unavailable: injected after the page loads, it can't see requests already pending,
+// and it must be re-injected after every navigation since it lives on window.
+const networkIdleScript = `
+	if (!window.__webNetwork) {
+		var state = window.__webNetwork = { inflight: 0, lastActivity: performance.now() };
+		var begin = function() {
+			state.inflight++;
+			state.lastActivity = performance.now();
+		};
+		var end = function() {
+			state.inflight = Math.max(0, state.inflight - 1);
+			state.lastActivity = performance.now();
+		};
+
+		if (window.fetch) {
+			var originalFetch = window.fetch;
+			window.fetch = function() {
+				begin();
+				try {
+					var promise = originalFetch.apply(this, arguments);
+					promise.then(end, end);
+					return promise;
+				} catch (e) {
+					end();
+					throw e;
+				}
+			};
+		}
+
+		var originalSend = XMLHttpRequest.prototype.send;
+		XMLHttpRequest.prototype.send = function() {
+			begin();
+			this.addEventListener('loadend', end);
+			return originalSend.apply(this, arguments);
+		};
+
+		if (window.PerformanceObserver) {
+			try {
+				new PerformanceObserver(function(list) {
+					list.getEntries().forEach(function(entry) {
+						state.lastActivity = Math.max(state.lastActivity, entry.responseEnd || entry.startTime);
+					});
+				}).observe({ type: 'resource', buffered: true });
+			} catch (e) {}
+		}
+	}
+`
+
 // getFirefoxPath returns the path to Firefox, checking PATH first (for Nix/system installs),
 // then falling back to the downloaded location in ~/.web-firefox/
 func getFirefoxPath() string {
@@ -55,28 +111,103 @@ type FormInput struct {
 	Value string
 }
 
+// FormUpload is a file for a form's file input, given with --upload
+type FormUpload struct {
+	Name string
+	Path string // absolute
+}
+
+// PageAction is a --js, --click or --click-text action, run in command-line order
+type PageAction struct {
+	Kind  string // "js", "click" or "click-text"
+	Value string
+	Frame string // --frame selector the action runs in, empty for the top-level page
+}
+
+// WaitCondition is an explicit condition to wait for before capturing the page
+type WaitCondition struct {
+	Kind  string // "selector", "js", "text", "gone" or "url" (flows only)
+	Value string
+}
+
 type Config struct {
-	URL            string
-	Profile        string
-	FormID         string
-	Inputs         []FormInput
-	AfterSubmitURL string
-	JSCode         string
-	ScreenshotPath string
-	TruncateAfter  int
-	RawFlag        bool
+	URL             string
+	FlowPath        string // set by `web run <flow.yaml>`
+	REPLFlag        bool   // set by `web repl [url]`
+	CrawlFlag       bool   // set by `web crawl <url>`
+	Profile         string
+	FormID          string
+	FormSelector    string
+	FormName        string
+	FormAction      string
+	Inputs          []FormInput
+	Uploads         []FormUpload
+	AfterSubmitURL  string
+	Actions         []PageAction
+	ScreenshotPath  string
+	TruncateAfter   int
+	RawFlag         bool
+	LockWait        time.Duration
+	ReadonlyFlag    bool
+	Waits           []WaitCondition
+	WaitTimeout     time.Duration
+	WaitUntil       string
+	IdleTime        time.Duration
+	WaitStable      time.Duration
+	HARPath         string
+	HARBodyLimit    int64
+	JSONFlag        bool
+	FailOnHTTPError bool
+	FailOnJSError   bool
+	Console         consoleOptions
+	NoConsole       bool
+	Dialog          dialogOptions
+	DownloadDir     string // absolute
+	ShadowDOM       bool
+	Scroll          bool
+	MaxScrolls      int
+	ScrollDelay     time.Duration
+	NextSelector    string
+	MaxPages        int // 0 until resolved to the --next or crawl default
+	CrawlDepth      int
+	Include         []string
+	Exclude         []string
+	OutDir          string
+	SitemapURL      string
+	Since           time.Time
+	Frames          frameOptions
 }
 
 func main() {
-	config := parseArgs()
+	config, err := parseArgs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	if config.URL == "" {
+	if config.URL == "" && config.FlowPath == "" && !config.REPLFlag {
 		printHelp()
 		os.Exit(1)
 	}
 
+	// Keep stdout clean for machine-readable output and REPL responses
+	if config.JSONFlag || config.REPLFlag {
+		statusOutput = os.Stderr
+	}
+	shadowDOMMode = config.ShadowDOM
+
+	// Validate the flow file before spending time on browser setup
+	var flow *flowFile
+	if config.FlowPath != "" {
+		flow, err = loadFlow(config.FlowPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Ensure Firefox and geckodriver are installed
-	err := ensureFirefox()
+	err = ensureFirefox()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error setting up Firefox: %v\n", err)
 		os.Exit(1)
@@ -88,10 +219,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Process the request
-	result, err := processRequest(config)
+	if config.REPLFlag {
+		if err := runREPL(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", redact(err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Process the request, run the flow file, or crawl the site
+	var result string
+	if flow != nil {
+		result, err = runFlow(config, flow)
+	} else if config.CrawlFlag {
+		result, err = runCrawl(config)
+	} else {
+		result, err = processRequest(config)
+	}
+	var checkErr *checkFailedError
+	if errors.As(err, &checkErr) {
+		// The page was still scraped; print it before failing
+		fmt.Println(result)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", redact(checkErr.Error()))
+		os.Exit(1)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error processing request: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error processing request: %v\n", redact(err.Error()))
 		os.Exit(1)
 	}
 
@@ -144,7 +297,7 @@ func ensureFirefox() error {
 	}
 
 	// Download and extract Firefox
-	fmt.Println("Firefox not found, downloading...")
+	statusf("Firefox not found, downloading...")
 	err = downloadFirefox(firefoxUrl, firefoxDir)
 	if err != nil {
 		return fmt.Errorf("failed to download Firefox: %v", err)
@@ -155,7 +308,7 @@ func ensureFirefox() error {
 		return fmt.Errorf("Firefox executable not found after download: %s", firefoxExec)
 	}
 
-	fmt.Printf("Firefox downloaded to: %s\n", firefoxDir)
+	statusf("Firefox downloaded to: %s", firefoxDir)
 	return nil
 }
 
@@ -198,7 +351,7 @@ func ensureGeckodriver() error {
 	}
 
 	// Download and extract geckodriver
-	fmt.Println("Geckodriver not found, downloading...")
+	statusf("Geckodriver not found, downloading...")
 	err = downloadAndExtractTarGz(geckoUrl, geckoDir)
 	if err != nil {
 		return fmt.Errorf("failed to download geckodriver: %v", err)
@@ -209,7 +362,7 @@ func ensureGeckodriver() error {
 		return fmt.Errorf("failed to make geckodriver executable: %v", err)
 	}
 
-	fmt.Printf("Geckodriver downloaded to: %s\n", geckoDir)
+	statusf("Geckodriver downloaded to: %s", geckoDir)
 	return nil
 }
 
@@ -221,7 +374,7 @@ func downloadAndExtractTarGz(url, destDir string) error {
 	}
 
 	// Download the tar.gz file
-	fmt.Printf("Downloading from %s...\n", url)
+	statusf("Downloading from %s...", url)
 	resp, err := http.Get(url)
 	if err != nil {
 		return fmt.Errorf("could not download: %v", err)
@@ -249,7 +402,7 @@ func downloadAndExtractTarGz(url, destDir string) error {
 	tempFile.Close()
 
 	// Extract using tar command
-	fmt.Println("Extracting geckodriver...")
+	statusf("Extracting geckodriver...")
 	return extractTarGz(tempFile.Name(), destDir)
 }
 
@@ -317,7 +470,7 @@ func downloadFirefox(url, destDir string) error {
 	}
 
 	// Download the zip file
-	fmt.Printf("Downloading Firefox from %s...\n", url)
+	statusf("Downloading Firefox from %s...", url)
 	resp, err := http.Get(url)
 	if err != nil {
 		return fmt.Errorf("could not download Firefox: %v", err)
@@ -345,7 +498,7 @@ func downloadFirefox(url, destDir string) error {
 	tempFile.Close()
 
 	// Extract the zip file
-	fmt.Println("Extracting Firefox...")
+	statusf("Extracting Firefox...")
 	return extractZip(tempFile.Name(), destDir)
 }
 
@@ -402,193 +555,93 @@ func extractZip(src, dest string) error {
 func processRequest(config Config) (string, error) {
 	baseURL := ensureProtocol(config.URL)
 
-	// Get Firefox and geckodriver paths (checks PATH first, then falls back to ~/.web-firefox/)
-	firefoxExec := getFirefoxPath()
-	geckoDriverPath := getGeckodriverPath()
-
-	// Get home directory for profile storage
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("could not get home directory: %v", err)
-	}
-
-	// Start geckodriver service
-	service, err := selenium.NewGeckoDriverService(geckoDriverPath, 4444)
-	if err != nil {
-		return "", fmt.Errorf("could not start geckodriver service: %v", err)
-	}
-	defer service.Stop()
-
-	// Configure Firefox with profile (profiles always stored in ~/.web-firefox/profiles/)
-	profileDir := filepath.Join(homeDir, ".web-firefox", "profiles", config.Profile)
-	os.MkdirAll(profileDir, 0755)
-
-	caps := selenium.Capabilities{
-		"browserName": "firefox",
-		"moz:firefoxOptions": map[string]interface{}{
-			"binary": firefoxExec,
-			"args":   []string{"-headless", "-profile", profileDir},
-			"prefs": map[string]interface{}{
-				"devtools.console.stdout.content": true,
-			},
-			"log": map[string]interface{}{
-				"level": "trace",
-			},
-		},
-	}
-
-	// Create WebDriver
-	wd, err := selenium.NewRemote(caps, fmt.Sprintf("http://localhost:%d", 4444))
+	session, err := startSession(config)
 	if err != nil {
-		return "", fmt.Errorf("could not create webdriver: %v", err)
+		return "", err
 	}
-	defer wd.Quit()
+	defer session.Close()
+	wd, events := session.wd, session.events
 
 	// Navigate to page
 	if err := wd.Get(baseURL); err != nil {
 		return "", fmt.Errorf("could not navigate to %s: %v", baseURL, err)
 	}
 
-	// Inject console capture script
-	_, err = wd.ExecuteScript(`
-		if (!window.__consoleMessages) {
-			window.__consoleMessages = [];
-			['log', 'warn', 'error', 'info', 'debug'].forEach(function(method) {
-				var original = console[method];
-				console[method] = function() {
-					var args = Array.prototype.slice.call(arguments);
-					var message = args.map(function(arg) {
-						if (typeof arg === 'object') {
-							try { return JSON.stringify(arg); }
-							catch(e) { return String(arg); }
-						}
-						return String(arg);
-					}).join(' ');
-					window.__consoleMessages.push({
-						level: method,
-						message: message
-					});
-					original.apply(console, arguments);
-				};
-			});
-		}
-	`, nil)
-	if err != nil {
-		fmt.Printf("Warning: Could not inject console capture: %v\n", err)
-	}
-
 	// Detect LiveView pages
-	isLiveView, err := wd.ExecuteScript("return document.querySelector('[data-phx-session]') !== null", nil)
-	if err != nil {
-		isLiveView = false
-	}
-
-	if isLiveView.(bool) {
-		fmt.Println("Detected Phoenix LiveView page, waiting for connection...")
-		// Wait for Phoenix LiveView to connect
-		err = waitForSelector(wd, ".phx-connected", 10*time.Second)
-		if err != nil {
-			fmt.Printf("Warning: Could not detect LiveView connection: %v\n", err)
-		} else {
-			fmt.Println("Phoenix LiveView connected")
-		}
+	isLiveView := detectLiveView(wd)
 
-		// Set up navigation tracking using Phoenix events for all page interactions
-		_, err = wd.ExecuteScript(`
-			if (!window.__phxNavigationState) {
-				window.__phxNavigationState = { loading: false };
-				document.addEventListener('phx:page-loading-start', function() {
-					window.__phxNavigationState.loading = true;
-				});
-				document.addEventListener('phx:page-loading-stop', function() {
-					window.__phxNavigationState.loading = false;
-				});
-			}
-		`, nil)
-		if err != nil {
-			fmt.Printf("Warning: Could not inject Phoenix navigation listeners: %v\n", err)
-		}
+	if config.WaitUntil == "networkidle" {
+		waitForNetworkIdle(wd, events, config.IdleTime, config.WaitTimeout)
 	}
 
+	// The main document response, looked up before forms or actions navigate away from it
+	docInfo := session.DocumentInfo(baseURL)
+
 	// Handle form submission if specified
-	if config.FormID != "" && len(config.Inputs) > 0 {
-		err = handleForm(wd, config, isLiveView.(bool))
+	if len(config.Inputs) > 0 || len(config.Uploads) > 0 {
+		err = handleForm(wd, config, isLiveView)
 		if err != nil {
 			return "", fmt.Errorf("error handling form: %v", err)
 		}
+		session.ExpectDownload()
+		if config.WaitUntil == "networkidle" {
+			waitForNetworkIdle(wd, events, config.IdleTime, config.WaitTimeout)
+		}
 	}
 
-	// Execute JavaScript if provided
-	if config.JSCode != "" {
-		// Store current URL before executing JS
+	// Run --js and --click/--click-text actions in the order given
+	for _, action := range config.Actions {
+		// Store current URL before the action
 		currentURL, _ := wd.CurrentURL()
-
-		_, err = wd.ExecuteScript(config.JSCode, nil)
-		if err != nil {
-			fmt.Printf("Warning: JavaScript execution failed: %v\n", err)
+		if err := markDocument(wd); err != nil {
+			return "", err
 		}
 
-		// Wait for navigation based on page type
-		if isLiveView.(bool) {
-			// For LiveView pages, wait for navigation using Phoenix events
-			fmt.Println("Waiting for Phoenix LiveView navigation...")
-
-			// First, wait briefly for loading to potentially start
-			time.Sleep(100 * time.Millisecond)
+		if action.Frame != "" {
+			if err := switchToFrame(wd, action.Frame); err != nil {
+				return "", err
+			}
+		}
 
-			// Check if navigation started
-			err = waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === true", 1*time.Second)
+		switch action.Kind {
+		case "js":
+			_, err = wd.ExecuteScript(action.Value, nil)
 			if err != nil {
-				// No navigation event detected, check if URL changed
-				newURL, _ := wd.CurrentURL()
-				if newURL != currentURL {
-					fmt.Println("URL changed, waiting for page to stabilize...")
-					time.Sleep(500 * time.Millisecond)
-				} else {
-					fmt.Println("Info: No navigation detected (in-place LiveView update)")
-				}
-			} else {
-				// Navigation started, wait for it to complete
-				err = waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === false", 10*time.Second)
-				if err != nil {
-					fmt.Printf("Warning: Navigation did not complete within timeout: %v\n", err)
-				} else {
-					fmt.Println("Phoenix LiveView navigation completed")
-				}
+				statusf("Warning: JavaScript execution failed: %v", err)
 			}
-		} else {
-			// For non-LiveView pages, wait for traditional navigation
-			fmt.Println("Waiting for page navigation...")
+		case "click", "click-text":
+			err = clickElement(wd, action)
+		}
+		session.ExpectDownload()
 
-			// Brief delay to allow navigation to start
-			time.Sleep(200 * time.Millisecond)
+		// Navigation is tracked from the top-level page
+		if action.Frame != "" {
+			wd.SwitchFrame(nil)
+		}
+		if err != nil && action.Kind != "js" {
+			return "", err
+		}
 
-			// Wait for URL to change or timeout
-			navigationOccurred := false
-			err = wd.WaitWithTimeout(func(wd selenium.WebDriver) (bool, error) {
-				newURL, err := wd.CurrentURL()
-				if err != nil {
-					return false, nil
-				}
-				if newURL != currentURL {
-					navigationOccurred = true
-					return true, nil
-				}
-				return false, nil
-			}, 5*time.Second)
+		if waitForNavigation(wd, currentURL, isLiveView, config) && !isLiveView {
+			isLiveView = detectLiveView(wd)
+		}
 
-			if navigationOccurred {
-				// Wait for page to be fully loaded
-				fmt.Println("Navigation detected, waiting for page load...")
-				err = waitForFunction(wd, "return document.readyState === 'complete'", 5*time.Second)
-				if err != nil {
-					fmt.Printf("Warning: Page load wait timed out: %v\n", err)
-				} else {
-					fmt.Println("Page load completed")
-				}
-			} else {
-				fmt.Println("Info: No navigation detected (page update without URL change)")
-			}
+		if config.WaitUntil == "networkidle" {
+			waitForNetworkIdle(wd, events, config.IdleTime, config.WaitTimeout)
+		}
+	}
+
+	// Wait for explicit conditions and expand infinite scroll, unless they're meant for the after-submit page
+	scrolls := 0
+	if config.AfterSubmitURL == "" {
+		if err := waitForConditions(wd, config.Waits, config.WaitTimeout); err != nil {
+			return "", err
+		}
+		if config.WaitStable > 0 {
+			waitForDOMStable(wd, config.WaitStable, config.WaitTimeout)
+		}
+		if config.Scroll {
+			scrolls = scrollPage(wd, config.MaxScrolls, config.ScrollDelay, config.WaitTimeout)
 		}
 	}
 
@@ -602,83 +655,124 @@ func processRequest(config Config) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("error saving screenshot: %v", err)
 		}
-		fmt.Printf("Screenshot saved to %s\n", config.ScreenshotPath)
+		statusf("Screenshot saved to %s", config.ScreenshotPath)
 	}
 
 	// Navigate to after-submit URL if provided
 	if config.AfterSubmitURL != "" {
-		fmt.Printf("Navigating to after-submit URL: %s\n", config.AfterSubmitURL)
+		statusf("Navigating to after-submit URL: %s", config.AfterSubmitURL)
 		if err := wd.Get(config.AfterSubmitURL); err != nil {
 			return "", fmt.Errorf("could not navigate to after-submit URL: %v", err)
 		}
+		if err := waitForConditions(wd, config.Waits, config.WaitTimeout); err != nil {
+			return "", err
+		}
+		if config.WaitStable > 0 {
+			waitForDOMStable(wd, config.WaitStable, config.WaitTimeout)
+		}
+		if config.Scroll {
+			scrolls = scrollPage(wd, config.MaxScrolls, config.ScrollDelay, config.WaitTimeout)
+		}
 	}
 
-	// Get page content
-	content, err := wd.PageSource()
+	// Wait for files the actions downloaded
+	downloads := session.Downloads(config.WaitTimeout)
+
+	// Get page content, with iframe content captured for inlining into the converted output
+	page, err := capturePageContent(wd, config)
 	if err != nil {
-		return "", fmt.Errorf("could not get page content: %v", err)
+		return "", err
 	}
+	pages := []capturedPage{page}
 
-	// Collect ALL logs: console logs (console.log/warn/error) AND browser logs (JS errors, network errors)
-	var consoleMessages []string
+	// Follow the next link through paginated results
+	if config.NextSelector != "" {
+		nextPages, nextScrolls := followPagination(wd, events, config, isLiveView, page)
+		pages = append(pages, nextPages...)
+		scrolls += nextScrolls
+	}
 
-	// 1. Collect console.log/warn/error messages from our injected capture
-	capturedLogs, err := wd.ExecuteScript("return window.__consoleMessages || []", nil)
-	if err == nil {
-		if logArray, ok := capturedLogs.([]interface{}); ok {
-			for _, logEntry := range logArray {
-				if logMap, ok := logEntry.(map[string]interface{}); ok {
-					level := "LOG"
-					if lvl, ok := logMap["level"].(string); ok {
-						level = strings.ToUpper(lvl)
-						// Normalize 'warn' to 'warning' to match expected format
-						if level == "WARN" {
-							level = "WARNING"
-						}
-					}
-					message := ""
-					if msg, ok := logMap["message"].(string); ok {
-						message = msg
-					}
-					consoleMessages = append(consoleMessages, fmt.Sprintf("[%s] %s", level, message))
-				}
-			}
-		}
+	// Collect console messages and JavaScript errors from BiDi events
+	consoleMessages, networkErrors, pageErrors := session.Diagnostics(config)
+	dialogs := session.Dialogs()
+
+	// Save recorded network traffic
+	if err := session.SaveHAR(); err != nil {
+		return "", err
 	}
 
-	// 2. Collect browser logs (JavaScript errors, security errors, network errors, etc.)
-	browserLogs, err := wd.Log(log.Browser)
-	if err == nil {
-		for _, logEntry := range browserLogs {
-			level := strings.ToUpper(string(logEntry.Level))
-			// Only include WARN, ERROR, SEVERE logs from browser to avoid noise
-			if level == "WARNING" || level == "WARN" || level == "ERROR" || level == "SEVERE" {
-				consoleMessages = append(consoleMessages, fmt.Sprintf("[%s] %s", level, logEntry.Message))
-			}
-		}
+	// Checks that fail the run after the result has been produced
+	var failure error
+	if config.FailOnHTTPError && docInfo != nil && docInfo.Status >= 400 {
+		failure = &checkFailedError{fmt.Sprintf("main document returned HTTP %d %s", docInfo.Status, docInfo.StatusText)}
+	} else if config.FailOnJSError && len(pageErrors) > 0 {
+		failure = &checkFailedError{fmt.Sprintf("page raised %d JavaScript error(s), first: %s", len(pageErrors), pageErrors[0].Message)}
+	}
+
+	output, fullLength, err := pagesOutput(pages, config.RawFlag)
+	if err != nil {
+		return "", err
 	}
 
 	// Return raw HTML if requested
-	if config.RawFlag {
-		return content, nil
+	if config.RawFlag && !config.JSONFlag {
+		return output, failure
 	}
 
-	// Convert HTML to markdown
-	text, err := html2text.FromString(content)
-	if err != nil {
-		return "", fmt.Errorf("could not convert HTML to text: %v", err)
+	truncated := false
+	if !config.RawFlag {
+		output, truncated = truncateOutput(output, fullLength, config.TruncateAfter)
 	}
 
-	// Clean and format the markdown
-	markdown := cleanMarkdown(text)
+	var pageURLs []string
+	if config.NextSelector != "" {
+		for _, page := range pages {
+			pageURLs = append(pageURLs, redact(page.URL))
+		}
+	}
 
-	// Truncate if specified
-	if len(markdown) > config.TruncateAfter {
-		markdown = markdown[:config.TruncateAfter] + fmt.Sprintf("\n\n... (output truncated after %d chars, full content was %d chars)", config.TruncateAfter, len(text))
+	var result string
+	if config.JSONFlag {
+		result, err = formatJSON(jsonResult{
+			URL:           baseURL,
+			Content:       output,
+			Truncated:     truncated,
+			Scrolls:       scrolls,
+			Pages:         pageURLs,
+			Console:       consoleMessages,
+			Dialogs:       dialogs,
+			Downloads:     downloads,
+			NetworkErrors: networkErrors,
+			Errors:        pageErrors,
+		}, docInfo)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// Add header with URL, response status and console messages
+		header := baseURL
+		if docInfo != nil {
+			header += "\n" + strings.Join(docInfo.headerLines(), "\n")
+		}
+		if config.Scroll {
+			header += fmt.Sprintf("\nScrolls: %d", scrolls)
+		}
+		if config.NextSelector != "" {
+			header += fmt.Sprintf("\nPages: %d", len(pages))
+		}
+		result = fmt.Sprintf("==========================\n%s\n==========================\n\n%s", header, output)
+
+		result += formatDownloads(downloads)
+		result += formatDiagnostics(consoleMessages, dialogs, networkErrors, pageErrors)
 	}
 
-	// Add header with URL and console messages
-	result := fmt.Sprintf("==========================\n%s\n==========================\n\n%s", baseURL, markdown)
+	return result, failure
+}
+
+// formatDiagnostics renders the CONSOLE OUTPUT, DIALOGS, NETWORK ERRORS and ERRORS sections,
+// omitting any that are empty
+func formatDiagnostics(consoleMessages []string, dialogs []dialogRecord, networkErrors []networkError, pageErrors []pageError) string {
+	var result string
 
 	// Add console messages if any
 	if len(consoleMessages) > 0 {
@@ -688,13 +782,126 @@ func processRequest(config Config) (string, error) {
 		}
 	}
 
-	return result, nil
+	// Add dialogs the page opened, and how they were answered, if any
+	if len(dialogs) > 0 {
+		result += "\n\n" + strings.Repeat("=", 50) + "\nDIALOGS:\n" + strings.Repeat("=", 50) + "\n"
+		for _, d := range dialogs {
+			result += formatDialog(d) + "\n"
+		}
+	}
+
+	// Add failed subresource requests if any
+	if len(networkErrors) > 0 {
+		result += "\n\n" + strings.Repeat("=", 50) + "\nNETWORK ERRORS:\n" + strings.Repeat("=", 50) + "\n"
+		for _, ne := range networkErrors {
+			result += formatNetworkError(ne) + "\n"
+		}
+	}
+
+	// Add uncaught errors with their stacks if any
+	if len(pageErrors) > 0 {
+		result += "\n\n" + strings.Repeat("=", 50) + "\nERRORS:\n" + strings.Repeat("=", 50) + "\n"
+		for _, pe := range pageErrors {
+			result += formatPageError(pe) + "\n"
+		}
+	}
+	return result
+}
+
+// formatNetworkError renders a failed request as "GET url -> 404 Not Found [img]"
+func formatNetworkError(ne networkError) string {
+	line := fmt.Sprintf("%s %s -> ", ne.Method, ne.URL)
+	if ne.Error != "" {
+		line += ne.Error
+	} else {
+		line += strings.TrimSpace(fmt.Sprintf("%d %s", ne.Status, ne.StatusText))
+	}
+	if ne.Initiator != "" {
+		line += fmt.Sprintf(" [%s]", ne.Initiator)
+	}
+	return line
+}
+
+// formatPageError renders an error as "[KIND] message (source:line:column)" followed by its indented stack
+func formatPageError(pe pageError) string {
+	kind := map[string]string{
+		"error":              "UNCAUGHT",
+		"unhandledrejection": "UNHANDLED REJECTION",
+		"javascript":         "UNCAUGHT",
+	}[pe.Kind]
+	line := fmt.Sprintf("[%s] %s", kind, pe.Message)
+	if pe.Source != "" {
+		line += fmt.Sprintf(" (%s:%d:%d)", pe.Source, pe.Line, pe.Column)
+	}
+	for _, frame := range strings.Split(strings.TrimSpace(pe.Stack), "\n") {
+		if frame != "" {
+			line += "\n    " + frame
+		}
+	}
+	return line
+}
+
+// checkFailedError is returned alongside a complete result when a --fail-on-* check fails
+type checkFailedError struct {
+	reason string
+}
+
+func (e *checkFailedError) Error() string {
+	return e.reason
+}
+
+// jsonResult is the structured output written by --json
+type jsonResult struct {
+	URL           string            `json:"url"`
+	FinalURL      string            `json:"final_url,omitempty"`
+	Status        int               `json:"status,omitempty"`
+	StatusText    string            `json:"status_text,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Redirects     []redirectHop     `json:"redirects,omitempty"`
+	Content       string            `json:"content"`
+	Truncated     bool              `json:"truncated"`
+	Scrolls       int               `json:"scrolls,omitempty"`
+	Pages         []string          `json:"pages,omitempty"`
+	Console       []string          `json:"console"`
+	Dialogs       []dialogRecord    `json:"dialogs,omitempty"`
+	Downloads     []downloadedFile  `json:"downloads,omitempty"`
+	NetworkErrors []networkError    `json:"network_errors"`
+	Errors        []pageError       `json:"errors"`
+}
+
+// formatJSON fills in the main document response and encodes the result
+func formatJSON(out jsonResult, docInfo *documentInfo) (string, error) {
+	if out.Console == nil {
+		out.Console = []string{}
+	}
+	if out.NetworkErrors == nil {
+		out.NetworkErrors = []networkError{}
+	}
+	if out.Errors == nil {
+		out.Errors = []pageError{}
+	}
+	if docInfo != nil {
+		out.FinalURL = docInfo.FinalURL
+		out.Status = docInfo.Status
+		out.StatusText = docInfo.StatusText
+		out.Redirects = docInfo.Redirects
+		out.Headers = map[string]string{}
+		for name := range docInfo.Headers {
+			out.Headers[name] = docInfo.Headers.Get(name)
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not encode JSON output: %v", err)
+	}
+	return string(data), nil
 }
 
 // waitForSelector waits for an element matching the selector to appear
 func waitForSelector(wd selenium.WebDriver, selector string, timeout time.Duration) error {
 	return wd.WaitWithTimeout(func(wd selenium.WebDriver) (bool, error) {
-		_, err := wd.FindElement(selenium.ByCSSSelector, selector)
+		_, err := findElement(wd, selector)
 		return err == nil, nil
 	}, timeout)
 }
@@ -713,61 +920,363 @@ func waitForFunction(wd selenium.WebDriver, jsCode string, timeout time.Duration
 	}, timeout)
 }
 
+// clickElement clicks the element for a --click selector or --click-text label with a
+// WebDriver element click, which scrolls it into view and fails if it can't be clicked
+func clickElement(wd selenium.WebDriver, action PageAction) error {
+	var elem selenium.WebElement
+	var err error
+	if action.Kind == "click-text" {
+		statusf("Clicking %q...", action.Value)
+		elem, err = findByText(wd, action.Value)
+	} else {
+		statusf("Clicking %s...", action.Value)
+		elem, err = findElement(wd, action.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("could not find element to click: %v", err)
+	}
+	if err := elem.Click(); err != nil {
+		return fmt.Errorf("could not click %s: %v", action.Value, err)
+	}
+	return nil
+}
+
+// findByText finds the clickable element (link, button, submit input, ...) whose visible
+// text, value or aria-label matches label, preferring exact matches and visible elements
+func findByText(wd selenium.WebDriver, label string) (selenium.WebElement, error) {
+	raw, err := wd.ExecuteScriptRaw(queryAllScript+`
+		var label = arguments[0].replace(/\s+/g, ' ').trim().toLowerCase();
+		var candidates = webQueryAll(
+			'a, button, input[type=submit], input[type=button], input[type=reset], summary, label, ' +
+			'[role=button], [role=link], [role=tab], [role=menuitem], [onclick], [phx-click]', arguments[1]);
+		var best = null, bestScore = 0;
+		for (var i = 0; i < candidates.length; i++) {
+			var el = candidates[i];
+			var texts = [el.innerText || el.textContent || '', el.value || '', el.getAttribute('aria-label') || ''];
+			var score = 0;
+			for (var j = 0; j < texts.length; j++) {
+				var text = String(texts[j]).replace(/\s+/g, ' ').trim().toLowerCase();
+				if (text === label) score = Math.max(score, 2);
+				else if (text && text.indexOf(label) !== -1) score = Math.max(score, 1);
+			}
+			if (!score) continue;
+			if (el.offsetWidth || el.offsetHeight || el.getClientRects().length) score += 2;
+			if (score > bestScore) { best = el; bestScore = score; }
+		}
+		return best;
+	`, []interface{}{label, shadowDOMMode})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return nil, err
+	}
+	if string(reply.Value) == "null" {
+		return nil, fmt.Errorf("no link or button with text %q", label)
+	}
+	return wd.DecodeElement(raw)
+}
+
+// markDocumentScript tags the current document and records when it starts to unload, so
+// waitForNavigation can tell whether an action replaced the page
+const markDocumentScript = `
+	window.__webDocumentMarker = true;
+	window.__webUnloading = false;
+	window.addEventListener('beforeunload', function() { window.__webUnloading = true; });
+`
+
+// navigationStartWait is how long an action gets to start a navigation before the page is
+// taken to have been updated in place
+const navigationStartWait = 1 * time.Second
+
+// markDocument prepares the top-level page for waitForNavigation; call it before the action
+func markDocument(wd selenium.WebDriver) error {
+	if _, err := wd.ExecuteScript(markDocumentScript, nil); err != nil {
+		return fmt.Errorf("could not prepare page for navigation tracking: %v", err)
+	}
+	return nil
+}
+
+// waitForNavigation waits for any navigation started by --js, a click or a flow step on a page
+// marked with markDocument, following Phoenix loading events on LiveView pages. It returns as
+// soon as it is clear that no navigation started, and reports whether the page or URL changed.
+func waitForNavigation(wd selenium.WebDriver, previousURL string, isLiveView bool, config Config) bool {
+	if isLiveView {
+		// LiveView navigation happens over the socket; follow the Phoenix loading events
+		statusf("Waiting for Phoenix LiveView navigation...")
+		err := waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === true", navigationStartWait)
+		if err == nil {
+			err = waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === false", config.WaitTimeout)
+			if err != nil {
+				statusf("Warning: Navigation did not complete within timeout: %v", err)
+			} else {
+				statusf("Phoenix LiveView navigation completed")
+			}
+			currentURL, _ := wd.CurrentURL()
+			return currentURL != previousURL
+		}
+
+		currentURL, _ := wd.CurrentURL()
+		if currentURL == previousURL {
+			statusf("Info: No navigation detected (in-place LiveView update)")
+			return false
+		}
+		statusf("URL changed, waiting for page to stabilize...")
+		if config.WaitStable > 0 {
+			waitForDOMStable(wd, config.WaitStable, config.WaitTimeout)
+		} else {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return true
+	}
+
+	// Give the action a moment to start a navigation, if it is going to
+	statusf("Waiting for page navigation...")
+	deadline := time.Now().Add(navigationStartWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+
+		state, err := wd.ExecuteScript(`return window.__webDocumentMarker ? (window.__webUnloading ? 'unloading' : 'same') : 'new'`, nil)
+		if err == nil && state == "same" {
+			// History API navigations keep the document but change the URL
+			if currentURL, err := wd.CurrentURL(); err == nil && currentURL != previousURL {
+				statusf("URL changed without a page load")
+				return true
+			}
+			continue
+		}
+
+		// The document is unloading or has been replaced; wait for the new one to load
+		statusf("Navigation detected, waiting for page load...")
+		err = waitForFunction(wd, "return !window.__webDocumentMarker && document.readyState === 'complete'", config.WaitTimeout)
+		if err != nil {
+			statusf("Warning: Page load wait timed out: %v", err)
+		} else {
+			statusf("Page load completed")
+		}
+		return true
+	}
+	statusf("Info: No navigation detected (page update without URL change)")
+	return false
+}
+
+// waitForConditions waits for each explicit --wait-* condition in order, sharing one timeout
+func waitForConditions(wd selenium.WebDriver, waits []WaitCondition, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, wait := range waits {
+		description, jsCode := conditionScript(wait)
+
+		statusf("Waiting for %s...", description)
+
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+		var err error
+		if wait.Kind == "selector" {
+			err = waitForSelector(wd, wait.Value, remaining)
+		} else {
+			err = waitForFunction(wd, jsCode, remaining)
+		}
+		if err != nil {
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, description)
+		}
+	}
+	return nil
+}
+
+// conditionScript describes a wait condition and returns the JavaScript that tests it
+// (empty for selector conditions, which are checked with findElement)
+func conditionScript(wait WaitCondition) (description, jsCode string) {
+	switch wait.Kind {
+	case "selector":
+		description = fmt.Sprintf("selector %q", wait.Value)
+	case "js":
+		description = fmt.Sprintf("JavaScript condition %q", wait.Value)
+		jsCode = fmt.Sprintf("return !!(%s)", wait.Value)
+	case "text":
+		description = fmt.Sprintf("text %q", wait.Value)
+		jsCode = fmt.Sprintf("return !!document.body && document.body.innerText.indexOf(%s) !== -1", jsString(wait.Value))
+	case "gone":
+		description = fmt.Sprintf("selector %q to disappear", wait.Value)
+		jsCode = fmt.Sprintf(`
+			var el = %s;
+			return !el || !(el.offsetWidth || el.offsetHeight || el.getClientRects().length);
+		`, queryScript(wait.Value))
+	case "url":
+		description = fmt.Sprintf("URL containing %q", wait.Value)
+		jsCode = fmt.Sprintf("return window.location.href.indexOf(%s) !== -1", jsString(wait.Value))
+	}
+	return description, jsCode
+}
+
+// checkCondition tests a wait condition once, without waiting
+func checkCondition(wd selenium.WebDriver, wait WaitCondition) (bool, error) {
+	if wait.Kind == "selector" {
+		elems, err := findElements(wd, wait.Value)
+		if err != nil {
+			return false, err
+		}
+		return len(elems) > 0, nil
+	}
+	_, jsCode := conditionScript(wait)
+	result, err := wd.ExecuteScript(jsCode, nil)
+	if err != nil {
+		return false, err
+	}
+	met, _ := result.(bool)
+	return met, nil
+}
+
+// waitForNetworkIdle waits until the page has loaded, no requests are in flight, and no
+// network activity has happened for the idle window. Requests are followed through BiDi
+// network events from the start of the session, so those started during page load count too.
+func waitForNetworkIdle(wd selenium.WebDriver, events *eventCapture, idle time.Duration, timeout time.Duration) {
+	idleCheck := func(wd selenium.WebDriver) (bool, error) {
+		if !events.NetworkIdle(idle) {
+			return false, nil
+		}
+		state, err := wd.ExecuteScript("return document.readyState", nil)
+		return err == nil && state == "complete", nil
+	}
+	if events == nil {
+		// Without BiDi, fall back to instrumenting fetch/XHR from inside the page
+		if _, err := wd.ExecuteScript(networkIdleScript, nil); err != nil {
+			statusf("Warning: Could not inject network instrumentation: %v", err)
+			return
+		}
+		idleCheck = func(wd selenium.WebDriver) (bool, error) {
+			result, err := wd.ExecuteScript(fmt.Sprintf(`
+				var state = window.__webNetwork;
+				return document.readyState === 'complete' && !!state && state.inflight === 0 &&
+					performance.now() - state.lastActivity >= %d;
+			`, idle.Milliseconds()), nil)
+			return err == nil && result == true, nil
+		}
+	}
+
+	statusf("Waiting for network idle...")
+	if err := wd.WaitWithTimeout(idleCheck, timeout); err != nil {
+		statusf("Warning: Network did not become idle within %s", timeout)
+	} else {
+		statusf("Network idle")
+	}
+}
+
+// waitForDOMStable waits until the DOM has gone the given window without any mutations
+func waitForDOMStable(wd selenium.WebDriver, window time.Duration, timeout time.Duration) {
+	_, err := wd.ExecuteScript(`
+		if (!window.__webMutations) {
+			var state = window.__webMutations = { lastMutation: performance.now() };
+			new MutationObserver(function() {
+				state.lastMutation = performance.now();
+			}).observe(document, { subtree: true, childList: true, attributes: true, characterData: true });
+		}
+	`, nil)
+	if err != nil {
+		statusf("Warning: Could not inject DOM mutation observer: %v", err)
+		return
+	}
+
+	statusf("Waiting for DOM to stabilize...")
+	err = waitForFunction(wd, fmt.Sprintf(`
+		var state = window.__webMutations;
+		return !!state && performance.now() - state.lastMutation >= %d;
+	`, window.Milliseconds()), timeout)
+	if err != nil {
+		statusf("Warning: DOM did not stabilize within %s", timeout)
+	} else {
+		statusf("DOM stable")
+	}
+}
+
+// jsString encodes s as a JavaScript string literal
+func jsString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
 func handleForm(wd selenium.WebDriver, config Config, isLiveView bool) error {
+	form, err := locateForm(wd, config)
+	if err != nil {
+		return err
+	}
+
 	// Fill form inputs
 	for _, input := range config.Inputs {
-		selector := fmt.Sprintf("#%s input[name='%s']", config.FormID, input.Name)
-		elem, err := wd.FindElement(selenium.ByCSSSelector, selector)
+		selector, err := fieldSelector(wd, form, input.Name, config)
 		if err != nil {
-			return fmt.Errorf("could not find input %s: %v", input.Name, err)
+			return fmt.Errorf("input %s: %v", input.Name, err)
 		}
-		if err := elem.Clear(); err != nil {
-			return fmt.Errorf("could not clear input %s: %v", input.Name, err)
+		if err := fillField(wd, selector, input.Value); err != nil {
+			return fmt.Errorf("input %s: %v", input.Name, err)
 		}
-		if err := elem.SendKeys(input.Value); err != nil {
-			return fmt.Errorf("could not fill input %s: %v", input.Name, err)
+	}
+
+	// Attach files, waiting for LiveView auto-uploads to finish before submitting
+	for _, upload := range config.Uploads {
+		if err := attachUpload(wd, form, upload, isLiveView, config.WaitTimeout); err != nil {
+			return err
 		}
 	}
 
 	if isLiveView {
 		// For LiveView, use Phoenix event-based navigation tracking
-		formSelector := fmt.Sprintf("#%s", config.FormID)
-		formElem, err := wd.FindElement(selenium.ByCSSSelector, formSelector)
+		formElem, err := findElement(wd, form)
 		if err != nil {
 			return fmt.Errorf("could not find LiveView form: %v", err)
 		}
 
+		// Note which uploads have entries before submitting: the server consumes them on
+		// submit, which clears the input's refs again
+		started := map[string]bool{}
+		for _, upload := range config.Uploads {
+			if status, err := liveUploadStatus(wd, form, upload); err == nil {
+				started[upload.Name] = status["started"]
+			}
+		}
+
 		// Submit the form by pressing Enter
 		if err := formElem.SendKeys(selenium.EnterKey); err != nil {
 			return fmt.Errorf("could not submit LiveView form: %v", err)
 		}
 
+		// Uploads without auto_upload are sent when the form is submitted
+		for _, upload := range config.Uploads {
+			if err := waitForLiveUpload(wd, form, upload, started[upload.Name], config.WaitTimeout); err != nil {
+				return err
+			}
+		}
+
 		// Wait for Phoenix navigation to complete (phx:page-loading-start -> phx:page-loading-stop)
-		fmt.Println("Waiting for Phoenix LiveView navigation...")
+		statusf("Waiting for Phoenix LiveView navigation...")
 
 		// First, wait for loading to start (with short timeout)
 		err = waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === true", 2*time.Second)
 		if err != nil {
-			fmt.Printf("Info: No navigation detected (this is normal for in-place updates)\n")
+			statusf("Info: No navigation detected (this is normal for in-place updates)")
 		} else {
 			// If navigation started, wait for it to complete
 			err = waitForFunction(wd, "return window.__phxNavigationState && window.__phxNavigationState.loading === false", 10*time.Second)
 			if err != nil {
-				fmt.Printf("Warning: Navigation did not complete within timeout: %v\n", err)
+				statusf("Warning: Navigation did not complete within timeout: %v", err)
 			} else {
-				fmt.Println("Phoenix LiveView navigation completed")
+				statusf("Phoenix LiveView navigation completed")
 			}
 		}
 
-		fmt.Println("LiveView form submitted")
+		statusf("LiveView form submitted")
 	} else {
 		// For regular forms, click submit button or press enter
-		submitSelector := fmt.Sprintf("#%s input[type='submit'], #%s button[type='submit']", config.FormID, config.FormID)
-		elem, err := wd.FindElement(selenium.ByCSSSelector, submitSelector)
+		submitSelector := within(form, "input[type='submit']") + ", " + within(form, "button[type='submit']")
+		elem, err := findElement(wd, submitSelector)
 		if err != nil {
 			// Try pressing Enter on the form if no submit button
-			formSelector := fmt.Sprintf("#%s", config.FormID)
-			formElem, err := wd.FindElement(selenium.ByCSSSelector, formSelector)
+			formElem, err := findElement(wd, form)
 			if err != nil {
 				return fmt.Errorf("could not submit form: %v", err)
 			}
@@ -779,19 +1288,145 @@ func handleForm(wd selenium.WebDriver, config Config, isLiveView bool) error {
 				return fmt.Errorf("could not click submit button: %v", err)
 			}
 		}
-		fmt.Println("Form submitted")
+		statusf("Form submitted")
 	}
 
 	return nil
 }
 
-func parseArgs() Config {
+// liveUploadScript reports on a LiveView upload input: whether it is a live_file_input, uses
+// auto_upload, has entries in progress (data-phx-active-refs) and has finished uploading every
+// one of them (data-phx-done-refs). An input removed by the submit's page update has finished too.
+const liveUploadScript = `
+	var input = %s;
+	if (!input) return {live: true, auto: false, done: true};
+	var refs = function(name) { return (input.getAttribute(name) || '').split(',').filter(Boolean); };
+	var active = refs('data-phx-active-refs');
+	var done = refs('data-phx-done-refs');
+	return {
+		live: input.hasAttribute('data-phx-upload-ref'),
+		auto: input.hasAttribute('data-phx-auto-upload'),
+		started: active.length > 0,
+		done: active.length > 0 && active.every(function(ref) { return done.indexOf(ref) !== -1; })
+	};
+`
+
+// resolveUpload checks that an --upload file exists and makes its path absolute, as the
+// browser resolves file input paths on its own
+func resolveUpload(name, path string) (FormUpload, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return FormUpload{}, fmt.Errorf("upload %s: %v", name, err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return FormUpload{}, fmt.Errorf("upload %s: file not found: %s", name, path)
+	}
+	if info.IsDir() {
+		return FormUpload{}, fmt.Errorf("upload %s: %s is a directory", name, path)
+	}
+	return FormUpload{Name: name, Path: absPath}, nil
+}
+
+// fileInputSelector selects an upload's file input within the form
+func fileInputSelector(form string, upload FormUpload) string {
+	return within(form, "input[type='file'][name="+cssString(upload.Name)+"]")
+}
+
+// attachUpload sends a file to a form's file input. For a LiveView live_file_input with
+// auto_upload, it waits for the upload to complete.
+func attachUpload(wd selenium.WebDriver, form string, upload FormUpload, isLiveView bool, timeout time.Duration) error {
+	elem, err := findElement(wd, fileInputSelector(form, upload))
+	if err != nil {
+		return fmt.Errorf("could not find file input %s: %v", upload.Name, err)
+	}
+	if err := elem.SendKeys(upload.Path); err != nil {
+		return fmt.Errorf("could not attach %s to %s: %v", upload.Path, upload.Name, err)
+	}
+	statusf("Attached %s to %s", filepath.Base(upload.Path), upload.Name)
+
+	if !isLiveView {
+		return nil
+	}
+	status, err := liveUploadStatus(wd, form, upload)
+	if err != nil || !status["live"] || !status["auto"] {
+		return nil
+	}
+	return waitForLiveUpload(wd, form, upload, status["started"], timeout)
+}
+
+// waitForLiveUpload waits until LiveView has uploaded every entry of a live_file_input.
+// Once entries have been seen (started), an input whose active refs are empty again has
+// had them consumed by the server, so that counts as finished too.
+func waitForLiveUpload(wd selenium.WebDriver, form string, upload FormUpload, started bool, timeout time.Duration) error {
+	finished := func(status map[string]bool) bool {
+		if status["started"] {
+			started = true
+		}
+		return status["done"] || (started && !status["started"])
+	}
+
+	status, err := liveUploadStatus(wd, form, upload)
+	if err != nil || !status["live"] || finished(status) {
+		return nil
+	}
+
+	statusf("Waiting for LiveView upload of %s...", upload.Name)
+	err = wd.WaitWithTimeout(func(wd selenium.WebDriver) (bool, error) {
+		status, err := liveUploadStatus(wd, form, upload)
+		return err == nil && finished(status), nil
+	}, timeout)
+	if err != nil {
+		return fmt.Errorf("upload %s did not complete within %s", upload.Name, timeout)
+	}
+	statusf("LiveView upload of %s completed", upload.Name)
+	return nil
+}
+
+// liveUploadStatus runs liveUploadScript for an upload's file input
+func liveUploadStatus(wd selenium.WebDriver, form string, upload FormUpload) (map[string]bool, error) {
+	result, err := wd.ExecuteScript(fmt.Sprintf(liveUploadScript, queryScript(fileInputSelector(form, upload))), nil)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := result.(map[string]interface{})
+	status := map[string]bool{}
+	for key, value := range raw {
+		status[key] = value == true
+	}
+	return status, nil
+}
+
+func parseArgs() (Config, error) {
 	config := Config{
 		TruncateAfter: DEFAULT_TRUNCATE_AFTER,
 		Profile:       "default",
+		WaitTimeout:   DEFAULT_WAIT_TIMEOUT,
+		WaitUntil:     "load",
+		IdleTime:      DEFAULT_IDLE_TIME,
+		HARBodyLimit:  DEFAULT_HAR_BODY_LIMIT,
+		MaxScrolls:    DEFAULT_MAX_SCROLLS,
+		ScrollDelay:   DEFAULT_SCROLL_DELAY,
+		CrawlDepth:    DEFAULT_CRAWL_DEPTH,
+		Frames:        frameOptions{MaxDepth: DEFAULT_FRAME_DEPTH},
 	}
 
 	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "run" {
+		if len(args) < 2 || strings.HasPrefix(args[1], "--") {
+			return config, fmt.Errorf("usage: web run <flow.yaml> [options]")
+		}
+		config.FlowPath = args[1]
+		args = args[2:]
+	} else if len(args) > 0 && args[0] == "repl" {
+		config.REPLFlag = true
+		stdinCommands = true
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == "crawl" {
+		config.CrawlFlag = true
+		args = args[1:]
+	}
+	frame := "" // --frame selector for the next action
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
@@ -801,6 +1436,12 @@ func parseArgs() Config {
 			os.Exit(0)
 		case "--raw":
 			config.RawFlag = true
+		case "--json":
+			config.JSONFlag = true
+		case "--fail-on-http-error":
+			config.FailOnHTTPError = true
+		case "--fail-on-js-error":
+			config.FailOnJSError = true
 		case "--truncate-after":
 			if i+1 < len(args) {
 				val, err := strconv.Atoi(args[i+1])
@@ -819,36 +1460,288 @@ func parseArgs() Config {
 				config.FormID = args[i+1]
 				i++
 			}
+		case "--form-selector":
+			if i+1 < len(args) {
+				config.FormSelector = args[i+1]
+				i++
+			}
+		case "--form-name":
+			if i+1 < len(args) {
+				config.FormName = args[i+1]
+				i++
+			}
+		case "--form-action":
+			if i+1 < len(args) {
+				config.FormAction = args[i+1]
+				i++
+			}
 		case "--input":
 			if i+1 < len(args) {
 				name := args[i+1]
 				i++
-				if i+1 < len(args) && args[i+1] == "--value" {
-					i++
-					if i+1 < len(args) {
-						value := args[i+1]
-						config.Inputs = append(config.Inputs, FormInput{Name: name, Value: value})
+				if i+1 < len(args) {
+					switch source := args[i+1]; source {
+					case "--value", "--value-env", "--value-file":
 						i++
+						if i+1 < len(args) {
+							value, err := resolveInputValue(source, args[i+1])
+							if err != nil {
+								return config, fmt.Errorf("input %s: %v", name, err)
+							}
+							config.Inputs = append(config.Inputs, FormInput{Name: name, Value: value})
+							i++
+						}
+					case "--value-stdin":
+						i++
+						value, err := resolveInputValue(source, "")
+						if err != nil {
+							return config, fmt.Errorf("input %s: %v", name, err)
+						}
+						config.Inputs = append(config.Inputs, FormInput{Name: name, Value: value})
 					}
 				}
 			}
-		case "--value":
+		case "--value", "--value-env", "--value-file", "--value-stdin":
 			// Skip, handled with --input
+		case "--upload":
+			if i+2 < len(args) {
+				upload, err := resolveUpload(args[i+1], args[i+2])
+				if err != nil {
+					return config, err
+				}
+				config.Uploads = append(config.Uploads, upload)
+				i += 2
+			} else {
+				return config, fmt.Errorf("usage: --upload <name> <path>")
+			}
 		case "--after-submit":
 			if i+1 < len(args) {
 				config.AfterSubmitURL = ensureProtocol(args[i+1])
 				i++
 			}
-		case "--js":
+		case "--js", "--click", "--click-text":
+			if i+1 < len(args) {
+				config.Actions = append(config.Actions, PageAction{Kind: strings.TrimPrefix(arg, "--"), Value: args[i+1], Frame: frame})
+				frame = ""
+				i++
+			}
+		case "--frame":
 			if i+1 < len(args) {
-				config.JSCode = args[i+1]
+				frame = args[i+1]
 				i++
 			}
+		case "--no-frames":
+			config.Frames.MaxDepth = 0
+		case "--frame-depth":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val < 0 {
+					return config, fmt.Errorf("invalid --frame-depth value: %s", args[i+1])
+				}
+				config.Frames.MaxDepth = val
+				i++
+			}
+		case "--cross-origin-frames":
+			config.Frames.CrossOrigin = true
 		case "--profile":
 			if i+1 < len(args) {
 				config.Profile = args[i+1]
 				i++
 			}
+		case "--wait-for", "--wait-for-js", "--wait-for-text", "--wait-gone":
+			if i+1 < len(args) {
+				kind := map[string]string{
+					"--wait-for":      "selector",
+					"--wait-for-js":   "js",
+					"--wait-for-text": "text",
+					"--wait-gone":     "gone",
+				}[arg]
+				config.Waits = append(config.Waits, WaitCondition{Kind: kind, Value: args[i+1]})
+				i++
+			}
+		case "--wait-timeout":
+			if i+1 < len(args) {
+				val, err := time.ParseDuration(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --wait-timeout duration: %s", args[i+1])
+				}
+				config.WaitTimeout = val
+				i++
+			}
+		case "--wait-until":
+			if i+1 < len(args) {
+				switch args[i+1] {
+				case "load", "networkidle":
+					config.WaitUntil = args[i+1]
+				default:
+					return config, fmt.Errorf("invalid --wait-until value: %s (expected load or networkidle)", args[i+1])
+				}
+				i++
+			}
+		case "--idle-time":
+			if i+1 < len(args) {
+				val, err := time.ParseDuration(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --idle-time duration: %s", args[i+1])
+				}
+				config.IdleTime = val
+				i++
+			}
+		case "--wait-stable":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --wait-stable value: %s (expected milliseconds)", args[i+1])
+				}
+				config.WaitStable = time.Duration(val) * time.Millisecond
+				i++
+			}
+		case "--har":
+			if i+1 < len(args) {
+				config.HARPath = args[i+1]
+				i++
+			}
+		case "--har-body-limit":
+			if i+1 < len(args) {
+				val, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || val < 0 {
+					return config, fmt.Errorf("invalid --har-body-limit value: %s (expected bytes)", args[i+1])
+				}
+				config.HARBodyLimit = val
+				i++
+			}
+		case "--console-level":
+			if i+1 < len(args) {
+				level, err := parseConsoleLevel(args[i+1])
+				if err != nil {
+					return config, err
+				}
+				config.Console.MinLevel = level
+				i++
+			}
+		case "--console-grep":
+			if i+1 < len(args) {
+				re, err := regexp.Compile(args[i+1])
+				if err != nil {
+					return config, fmt.Errorf("invalid --console-grep pattern: %v", err)
+				}
+				config.Console.Grep = re
+				i++
+			}
+		case "--console-limit":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --console-limit value: %s", args[i+1])
+				}
+				config.Console.Limit = val
+				i++
+			}
+		case "--scroll":
+			config.Scroll = true
+		case "--max-scrolls":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --max-scrolls value: %s", args[i+1])
+				}
+				config.MaxScrolls = val
+				i++
+			}
+		case "--scroll-delay":
+			if i+1 < len(args) {
+				val, err := time.ParseDuration(args[i+1])
+				if err != nil || val < 0 {
+					return config, fmt.Errorf("invalid --scroll-delay duration: %s", args[i+1])
+				}
+				config.ScrollDelay = val
+				i++
+			}
+		case "--next":
+			if i+1 < len(args) {
+				config.NextSelector = args[i+1]
+				i++
+			}
+		case "--max-pages":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val <= 0 {
+					return config, fmt.Errorf("invalid --max-pages value: %s", args[i+1])
+				}
+				config.MaxPages = val
+				i++
+			}
+		case "--depth":
+			if i+1 < len(args) {
+				val, err := strconv.Atoi(args[i+1])
+				if err != nil || val < 0 {
+					return config, fmt.Errorf("invalid --depth value: %s", args[i+1])
+				}
+				config.CrawlDepth = val
+				i++
+			}
+		case "--include":
+			if i+1 < len(args) {
+				config.Include = append(config.Include, args[i+1])
+				i++
+			}
+		case "--exclude":
+			if i+1 < len(args) {
+				config.Exclude = append(config.Exclude, args[i+1])
+				i++
+			}
+		case "--sitemap":
+			if i+1 < len(args) {
+				config.SitemapURL = args[i+1]
+				i++
+			}
+		case "--since":
+			if i+1 < len(args) {
+				val, err := parseSitemapDate(args[i+1])
+				if err != nil {
+					return config, fmt.Errorf("invalid --since value: %v", err)
+				}
+				config.Since = val
+				i++
+			}
+		case "--out":
+			if i+1 < len(args) {
+				config.OutDir = args[i+1]
+				i++
+			}
+		case "--shadow-dom":
+			config.ShadowDOM = true
+		case "--no-console":
+			config.NoConsole = true
+		case "--download-dir":
+			if i+1 < len(args) {
+				dir, err := filepath.Abs(args[i+1])
+				if err != nil {
+					return config, fmt.Errorf("invalid --download-dir: %v", err)
+				}
+				config.DownloadDir = dir
+				i++
+			}
+		case "--dialog":
+			if i+1 < len(args) {
+				val, err := parseDialogOption(args[i+1])
+				if err != nil {
+					return config, err
+				}
+				config.Dialog = val
+				i++
+			}
+		case "--profile-readonly":
+			config.ReadonlyFlag = true
+		case "--lock-wait":
+			if i+1 < len(args) {
+				val, err := time.ParseDuration(args[i+1])
+				if err != nil || val < 0 {
+					return config, fmt.Errorf("invalid --lock-wait duration: %s", args[i+1])
+				}
+				config.LockWait = val
+				i++
+			}
 		default:
 			if config.URL == "" && !strings.HasPrefix(arg, "--") {
 				config.URL = arg
@@ -856,25 +1749,152 @@ func parseArgs() Config {
 		}
 	}
 
-	return config
+	if frame != "" {
+		return config, fmt.Errorf("--frame must be followed by --js, --click or --click-text")
+	}
+	// A sitemap run writes pages like a crawl
+	if config.SitemapURL != "" {
+		config.CrawlFlag = true
+	}
+	if config.CrawlFlag && config.URL == "" && config.SitemapURL == "" {
+		return config, fmt.Errorf("usage: web crawl <url> [options] or web crawl --sitemap <url> [options]")
+	}
+	formOptions := 0
+	for _, option := range []string{config.FormID, config.FormSelector, config.FormName, config.FormAction} {
+		if option != "" {
+			formOptions++
+		}
+	}
+	if formOptions > 1 {
+		return config, fmt.Errorf("use only one of --form, --form-selector, --form-name and --form-action")
+	}
+	if config.MaxPages == 0 {
+		config.MaxPages = DEFAULT_MAX_PAGES
+		if config.CrawlFlag {
+			config.MaxPages = DEFAULT_CRAWL_MAX_PAGES
+		}
+	}
+
+	return config, nil
 }
 
 func printHelp() {
 	fmt.Printf(`web - portable web scraper for llms
 
 Usage: web <url> [options]
+       web run <flow.yaml> [options]
+       web repl [url] [options]
+       web crawl <url> [options]
+       web crawl --sitemap <url> [options]
 
 Options:
   --help                     Show this help message
   --raw                      Output raw page instead of converting to markdown
+  --json                     Output a JSON object with the content, HTTP status, headers, redirects and
+                             console messages (status messages go to stderr)
+  --fail-on-http-error       Exit non-zero if the main document responds with a 4xx/5xx status
+  --fail-on-js-error         Exit non-zero if the page raises an uncaught error or unhandled rejection
+  --console-level <level>    Only show console messages at or above debug, log, info, warn or error
+  --console-grep <regex>     Only show console messages matching the regular expression
+  --console-limit <number>   Show at most <number> console messages (repeats are collapsed into "(xN)")
+  --no-console               Omit console output
+  --download-dir <path>      Save downloads started by the page or actions into the directory, wait for them
+                             to finish and list each file's name, size and MIME type (after a click, form
+                             submission or --js, a download has 2s to start; later ones are missed)
+  --dialog <answer>          Answer alert, confirm, prompt and beforeunload dialogs with accept, dismiss or
+                             text=<value> (accepts prompts with the value); dialogs are listed in the output
+                             (default: dismiss, but leave the page on beforeunload)
+  --scroll                   Scroll to the bottom until the page stops growing (infinite scroll, lazy loading),
+                             then wait for lazy images and iframes
+  --max-scrolls <number>     Maximum number of scrolls for --scroll (default: %d)
+  --scroll-delay <duration>  Time to wait for new content after each scroll (default: %s)
+  --next <css>               Follow the matching next-page link or button and append each page's content
+                             under a "--- Page N: url ---" header, until it is missing or repeats a URL
+  --max-pages <number>       Maximum number of pages to capture with --next, including the first (default: %d),
+                             or to crawl (default: %d)
+  --depth <number>           Follow links this many levels from the start page when crawling (default: %d)
+  --include <glob>           Only crawl URL paths matching the glob, e.g. "/docs/**" (repeatable)
+  --exclude <glob>           Don't crawl URL paths matching the glob (repeatable)
+  --out <dir>                Directory for crawled pages and index.json (default: the site's host name)
+  --sitemap <url>            Crawl the pages listed by a sitemap or sitemap index (gzip too) instead of
+                             following links; a path like /sitemap.xml resolves against the start URL,
+                             which (when given) decides the site even if the sitemap is on another host
+  --since <date>             With --sitemap, skip pages whose <lastmod> is before the date (e.g. 2024-01-31)
+  --shadow-dom               Flatten open and declarative shadow roots into the page content, and match
+                             --form, --wait-*, --click and flow selectors inside shadow roots
+  --frame-depth <number>     Inline iframe content up to <number> levels deep, 0 to leave it out (default: %d)
+  --no-frames                Leave iframe content out, same as --frame-depth 0
+  --cross-origin-frames      Also inline iframes from other origins (ads, embeds, payment widgets)
   --truncate-after <number>  Truncate output after <number> characters and append a notice (default: %d)
   --screenshot <filepath>    Take a screenshot of the page and save it to the given filepath
-  --form <id>                The id of the form for inputs
-  --input <name>             Specify the name attribute for a form input field
-  --value <value>            Provide the value to fill for the last --input field
+  --form <id>                The id of the form for inputs (used as given, e.g. "user[profile]"); without a
+                             form option, the page's only form is used
+  --form-selector <css>      Select the form for inputs with a CSS selector
+  --form-name <name>         Select the form for inputs by its name attribute
+  --form-action <path>       Select the form for inputs by the URL it submits to, e.g. /users/log-in
+  --input <name>             Specify the name attribute for a form field (input, textarea, select, checkbox,
+                             radio), or a CSS selector within the form starting with #, . or [, e.g. for a
+                             contenteditable editor
+  --value <value>            Provide the value to fill for the last --input field: text, an option's value or
+                             label for a select, true/false or the value to pick for checkboxes and radios
+                             (@secret:env:VAR, @secret:file:PATH or @secret:stdin read it as a secret)
+  --value-env <var>          Read the value for the last --input field from an environment variable
+  --value-file <path>        Read the value for the last --input field from a file
+  --value-stdin              Read the value for the last --input field from stdin
+  --upload <name> <path>     Attach a file to the form's file input with the given name (repeatable); waits
+                             for LiveView uploads to finish
   --after-submit <url>       After form submission and navigation, load this URL before converting to markdown
   --js <code>                Execute JavaScript code on the page after it loads
+  --frame <css>              Run the next --js, --click or --click-text inside the iframe matching the selector
+                             (separate nested frames with ">>", e.g. "#outer >> iframe.editor")
+  --click <css>              Click the element matching the selector and wait for any navigation
+  --click-text <label>       Click the link or button with the given visible text
+                             (--js, --click and --click-text are repeatable and run in the order given)
+  --wait-for <css>           Wait until an element matching the selector exists
+  --wait-for-js <expr>       Wait until the JavaScript expression is truthy
+  --wait-for-text <string>   Wait until the page text contains the string
+  --wait-gone <css>          Wait until no visible element matches the selector (e.g. a spinner)
+  --wait-timeout <duration>  Maximum total time for --wait-* conditions (default: %s)
+  --wait-until <state>       "load" (default) or "networkidle" to also wait for all requests, including
+                             those started during page load, to settle after load, form submission and --js
+  --idle-time <duration>     Quiet window required for networkidle (default: %s)
+  --wait-stable <ms>         Wait until the DOM has not changed for <ms> milliseconds before capturing
+  --har <filepath>           Record all network traffic through a local proxy and save it as a HAR file
+                             (HTTPS is re-signed by a local CA the profile trusts during the run; needs certutil)
+  --har-body-limit <bytes>   Skip recording response bodies larger than <bytes> (default: %d)
   --profile <name>           Use or create named session profile (default: "default")
+  --profile-readonly         Run against a temporary copy of the profile, leaving the original untouched
+                             (works while another run holds the profile, though the copy may be inconsistent)
+  --lock-wait <duration>     Wait up to <duration> (e.g. 30s) for a profile in use by another run (default: 0)
+
+Flows:
+A flow file lists steps run in order in one browser session; ${VAR} is read from the environment
+(values interpolated into fill are treated as secrets). Each step's output and timing is reported,
+and the run stops with a non-zero exit at the first failing step.
+  steps:
+    - goto: https://example.com/login         # paths like /account resolve against the current page
+    - fill: { selector: "#email", value: "${EMAIL}" }
+    - click: "button[type=submit]"            # waits for any navigation it triggers
+    - select: { selector: "#country", value: "NL" }
+    - wait: ".dashboard"                      # or { text | js | gone | url: ... }
+    - assert: { text: "Welcome" }             # checked once, without waiting
+    - js: "return document.title"
+    - extract: { selector: ".price", name: price, attribute: content }   # omit selector for the page
+    - screenshot: dashboard.png
+
+REPL:
+web repl keeps one browser session open and reads commands from stdin, one per line:
+goto <url>, click <css>, fill <css> <value>, js <code>, text [css], screenshot <path>,
+back, forward, reload, help and quit. Each response is framed as
+"=== <command> ok|error (<time>) <url>" ... "=== end", or one JSON object per line with --json.
+
+Crawling:
+web crawl renders every same-origin page linked from the start URL, up to --depth links away,
+in one browser session. Links are deduplicated after normalization, robots.txt rules and
+Crawl-delay are honoured, and each page is written to its own file under --out (e.g. /docs/intro
+becomes docs/intro.md), with index.json mapping each URL to its file, title and HTTP status.
+With --sitemap, the listed pages (filtered by --since, --include and --exclude) are rendered
+instead, without following their links. Pages are rendered one at a time.
 
 Phoenix LiveView Support:
 This tool automatically detects Phoenix LiveView applications and properly handles:
@@ -885,8 +1905,49 @@ This tool automatically detects Phoenix LiveView applications and properly handl
 Examples:
   web https://example.com
   web https://example.com --screenshot page.png --truncate-after 5000
-  web localhost:4000/login --form login_form --input email --value test@example.com --input password --value secret
-`, DEFAULT_TRUNCATE_AFTER)
+  web localhost:4000/login --form login_form --input email --value test@example.com --input password --value-env PASSWORD
+`, DEFAULT_MAX_SCROLLS, DEFAULT_SCROLL_DELAY, DEFAULT_MAX_PAGES, DEFAULT_CRAWL_MAX_PAGES, DEFAULT_CRAWL_DEPTH, DEFAULT_FRAME_DEPTH, DEFAULT_TRUNCATE_AFTER, DEFAULT_WAIT_TIMEOUT, DEFAULT_IDLE_TIME, DEFAULT_HAR_BODY_LIMIT)
+}
+
+// pageMarkdown converts page HTML to cleaned markdown with captured iframe content
+// inlined, truncated after limit characters
+func pageMarkdown(content string, frames map[string]string, limit int) (string, bool, error) {
+	text, err := htmlToText(content)
+	if err != nil {
+		return "", false, err
+	}
+	output, truncated := truncateOutput(substituteFrames(cleanMarkdown(text), frames), len(text), limit)
+	return output, truncated, nil
+}
+
+// truncateOutput cuts converted output after limit characters and appends a notice giving
+// the full length of the converted text
+func truncateOutput(output string, fullLength int, limit int) (string, bool) {
+	if len(output) > limit {
+		return output[:limit] + fmt.Sprintf("\n\n... (output truncated after %d chars, full content was %d chars)", limit, fullLength), true
+	}
+	return output, false
+}
+
+// htmlToMarkdown converts HTML to cleaned markdown
+func htmlToMarkdown(content string) (string, error) {
+	text, err := htmlToText(content)
+	if err != nil {
+		return "", err
+	}
+
+	// Clean and format the markdown
+	return cleanMarkdown(text), nil
+}
+
+// htmlToText converts HTML to markdown before cleaning; the truncation notice reports
+// its length as the full content
+func htmlToText(content string) (string, error) {
+	text, err := html2text.FromString(content)
+	if err != nil {
+		return "", fmt.Errorf("could not convert HTML to text: %v", err)
+	}
+	return text, nil
 }
 
 // Ensure URL has protocol
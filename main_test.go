@@ -1,16 +1,25 @@
 package main
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 var (
@@ -56,6 +65,495 @@ func startTestServer() {
 </html>`)
 		})
 
+		// Form with a file input; the POST reports the file it received
+		mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			if r.Method == http.MethodPost {
+				file, header, err := r.FormFile("attachment")
+				if err != nil {
+					fmt.Fprintf(w, "<p>No file: %v</p>", err)
+					return
+				}
+				defer file.Close()
+				data, _ := io.ReadAll(file)
+				fmt.Fprintf(w, "<p>Received %s (%d bytes) from %s</p>", header.Filename, len(data), r.FormValue("title"))
+				return
+			}
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Upload</title></head>
+<body>
+<form id="upload-form" method="post" enctype="multipart/form-data">
+<input name="title" type="text">
+<input name="attachment" type="file">
+<button type="submit">Upload</button>
+</form>
+</body>
+</html>`)
+		})
+
+		// LiveView form with a live_file_input, mimicking how LiveView updates the input's
+		// refs: selecting a file adds an active ref, an upload adds it to the done refs, and
+		// the server consuming entries on submit re-renders the input with no refs at all.
+		// ?auto=1 uploads on selection (auto_upload); otherwise the upload is sent on submit.
+		mux.HandleFunc("/live-upload", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			auto := ""
+			if r.URL.Query().Get("auto") == "1" {
+				auto = " data-phx-auto-upload"
+			}
+			fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>Live Upload</title></head>
+<body>
+<div data-phx-session="test-session" class="phx-connected">
+<form id="avatar-form" tabindex="-1">
+<input id="avatar" name="avatar" type="file" data-phx-upload-ref="phx-ref-0"%s>
+</form>
+<p id="result"></p>
+</div>
+<script>
+var input = document.getElementById('avatar'), auto = input.hasAttribute('data-phx-auto-upload');
+var uploaded = function() { input.setAttribute('data-phx-done-refs', '0'); };
+input.addEventListener('change', function() {
+	input.setAttribute('data-phx-active-refs', '0');
+	if (auto) setTimeout(uploaded, 300);
+});
+document.getElementById('avatar-form').addEventListener('keydown', function(e) {
+	if (e.key !== 'Enter') return;
+	var name = input.files.length ? input.files[0].name : 'nothing';
+	setTimeout(function() {
+		input.setAttribute('data-phx-active-refs', '');
+		input.setAttribute('data-phx-done-refs', '');
+		document.getElementById('result').textContent = 'Saved ' + name;
+	}, 300);
+});
+</script>
+</body>
+</html>`, auto)
+		})
+
+		// Page with export links that download a CSV attachment and one of an uncommon type
+		mux.HandleFunc("/reports", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Reports</title></head>
+<body><a id="export" href="/export.csv">Download CSV</a> <a id="export-ndjson" href="/export.ndjson">Download NDJSON</a></body>
+</html>`)
+		})
+		mux.HandleFunc("/export.ndjson", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.ndjson"`)
+			fmt.Fprint(w, "{\"month\":\"jan\",\"total\":10}\n")
+		})
+		mux.HandleFunc("/export.csv", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+			fmt.Fprint(w, "month,total\njan,10\nfeb,20\n")
+		})
+
+		// Form with a select, textarea, checkbox, radio group and contenteditable editor that
+		// shows what it would submit
+		mux.HandleFunc("/fields", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Fields</title></head>
+<body>
+<form id="fields-form" onsubmit="document.getElementById('out').textContent = Array.from(new FormData(this)).map(function(e) { return e.join('='); }).join('; ') + '; editor=' + document.getElementById('editor').textContent; return false;">
+<select name="country"><option value="">Choose</option><option value="NL">Netherlands</option><option value="BE">Belgium</option></select>
+<textarea name="bio"></textarea>
+<input type="checkbox" name="terms" value="yes">
+<input type="radio" name="plan" value="free" checked>
+<input type="radio" name="plan" value="pro">
+<div id="editor" contenteditable="true"></div>
+<button type="submit">Save</button>
+</form>
+<p id="out"></p>
+</body>
+</html>`)
+		})
+
+		// Two forms, one with an id that needs escaping and one found by name or action,
+		// each showing what it would submit
+		mux.HandleFunc("/forms", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Forms</title></head>
+<body>
+<form id="user[profile]" onsubmit="document.getElementById('out').textContent = 'profile: ' + this.elements['user[name]'].value; return false;">
+<input type="text" name="user[name]">
+<button type="submit">Save</button>
+</form>
+<form name="login" action="/session" onsubmit="document.getElementById('out').textContent = 'login: ' + this.elements['email'].value; return false;">
+<input type="text" name="email">
+<button type="submit">Log in</button>
+</form>
+<p id="out"></p>
+</body>
+</html>`)
+		})
+
+		// Form that echoes its submitted password to the console instead of navigating
+		mux.HandleFunc("/echo-form", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Echo Form</title></head>
+<body>
+<form id="echo-form" onsubmit="console.log('submitted password:', this.password.value); return false;">
+<input name="username" type="text">
+<input name="password" type="password">
+<button type="submit">Submit</button>
+</form>
+</body>
+</html>`)
+		})
+
+		// Page that renders its content after load, like an SPA fetching data
+		mux.HandleFunc("/delayed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Delayed Content</title></head>
+<body>
+<div class="spinner">Loading...</div>
+<div id="results"></div>
+<script>
+setTimeout(function() {
+	document.getElementById('results').innerHTML = '<p id="loaded">Delayed content arrived</p>';
+	document.querySelector('.spinner').remove();
+}, 1000);
+</script>
+</body>
+</html>`)
+		})
+
+		// Slow JSON endpoint used by the SPA page
+		mux.HandleFunc("/api/data", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(1 * time.Second)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"message": "Fetched data rendered"}`)
+		})
+
+		// Page that renders data fetched after load
+		mux.HandleFunc("/spa", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>SPA</title></head>
+<body>
+<div id="app">Empty</div>
+<script>
+setTimeout(function() {
+	fetch('/api/data').then(function(r) { return r.json(); }).then(function(data) {
+		document.getElementById('app').textContent = data.message;
+	});
+}, 100);
+</script>
+</body>
+</html>`)
+		})
+
+		// Page whose data request starts while the page is still loading
+		mux.HandleFunc("/spa-early", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>SPA</title>
+<script>
+fetch('/api/data').then(function(r) { return r.json(); }).then(function(data) {
+	document.getElementById('app').textContent = data.message;
+});
+</script>
+</head>
+<body>
+<div id="app">Empty</div>
+</body>
+</html>`)
+		})
+
+		// Page that keeps rendering items for a while after load
+		mux.HandleFunc("/rendering", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Rendering</title></head>
+<body>
+<ul id="items"></ul>
+<script>
+var count = 0;
+var timer = setInterval(function() {
+	count++;
+	var li = document.createElement('li');
+	li.textContent = 'Item ' + count;
+	document.getElementById('items').appendChild(li);
+	if (count === 10) {
+		clearInterval(timer);
+		li.textContent = 'Final item rendered';
+	}
+}, 150);
+</script>
+</body>
+</html>`)
+		})
+
+		// Redirects to the basic page
+		mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/", http.StatusFound)
+		})
+
+		// Error page with a real body
+		mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Not Found</title></head>
+<body><h1>Custom Not Found Page</h1></body>
+</html>`)
+		})
+
+		// Page that logs while it is still loading
+		mux.HandleFunc("/load-log", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Load Log</title><script>console.log('logged during page load');</script></head>
+<body><h1>Load Log</h1></body>
+</html>`)
+		})
+
+		// Page with a missing image and a failing API call
+		mux.HandleFunc("/broken-assets", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Broken Assets</title></head>
+<body>
+<h1>Broken Assets</h1>
+<img src="/missing-image.png">
+<script>fetch('/missing').then(function() { document.body.dataset.fetched = 'yes'; });</script>
+</body>
+</html>`)
+		})
+
+		// Infinite scroll feed that loads a new batch of items each time the bottom is reached
+		mux.HandleFunc("/infinite", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Infinite Feed</title></head>
+<body>
+<div id="feed"></div>
+<script>
+var batches = 0;
+function loadBatch() {
+	var feed = document.getElementById('feed');
+	for (var i = 1; i <= 5; i++) {
+		var item = document.createElement('div');
+		item.style.height = '400px';
+		item.textContent = 'Feed item ' + (batches * 5 + i);
+		feed.appendChild(item);
+	}
+	batches++;
+}
+loadBatch();
+window.addEventListener('scroll', function() {
+	if (batches < 4 && window.innerHeight + window.scrollY >= document.body.scrollHeight - 10) {
+		setTimeout(loadBatch, 100);
+	}
+});
+</script>
+</body>
+</html>`)
+		})
+
+		// Small site for crawling: /site/ links to a, b and a page robots.txt disallows;
+		// b links one level deeper
+		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /site/private\n")
+		})
+		sitePages := map[string]string{
+			"/site/":        `<h1>Site home</h1><a href="/site/a">A</a> <a href="b#top">B</a> <a href="/site/private">Private</a> <a href="https://example.com/">Elsewhere</a>`,
+			"/site/a":       `<h1>Page A</h1><a href="/site/">Home</a> <a href="/site/b">B</a>`,
+			"/site/b":       `<h1>Page B</h1><a href="/site/b/deep">Deep</a>`,
+			"/site/b/deep":  `<h1>Deep page</h1>`,
+			"/site/private": `<h1>Private page</h1>`,
+		}
+		mux.HandleFunc("/site/", func(w http.ResponseWriter, r *http.Request) {
+			body, ok := sitePages[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>%s</title></head><body>%s</body></html>", r.URL.Path, body)
+		})
+
+		// Sitemap index pointing at a gzipped sitemap of the crawl site's pages
+		mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>`+testServerURL+`/sitemap-pages.xml.gz</loc></sitemap>
+</sitemapindex>`)
+		})
+		mux.HandleFunc("/sitemap-pages.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			fmt.Fprint(gz, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>`+testServerURL+`/site/a</loc><lastmod>2024-01-15</lastmod></url>
+<url><loc>`+testServerURL+`/site/b</loc><lastmod>2025-06-01T10:00:00+00:00</lastmod></url>
+<url><loc>`+testServerURL+`/site/b/deep</loc></url>
+<url><loc>https://example.com/elsewhere</loc></url>
+</urlset>`)
+		})
+
+		// Page that alerts on load and asks for confirmation and a name on click
+		mux.HandleFunc("/dialogs", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Dialogs</title></head>
+<body>
+<button id="delete" onclick="document.getElementById('result').textContent = confirm('Delete this item?') ? 'Deleted' : 'Kept'">Delete</button>
+<button id="rename" onclick="document.getElementById('result').textContent = 'Name: ' + prompt('New name?', 'untitled')">Rename</button>
+<p id="result">Waiting</p>
+<script>alert('Welcome back')</script>
+</body>
+</html>`)
+		})
+
+		// Paginated listing with three pages; the last page links back to the first
+		mux.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 1 {
+				page = 1
+			}
+			next := fmt.Sprintf("/list?page=%d", page+1)
+			if page == 3 {
+				next = "/list"
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>Listing</title></head>
+<body>
+<p>Result %d-a</p>
+<p>Result %d-b</p>
+<a rel="next" href="%s">Next</a>
+</body>
+</html>`, page, page, next)
+		})
+
+		// Page embedding a same-origin iframe, which itself embeds a srcdoc iframe
+		mux.HandleFunc("/with-frames", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>With Frames</title></head>
+<body>
+<p>Before the frame</p>
+<iframe id="widget" src="/frame-child"></iframe>
+<p>After the frame</p>
+</body>
+</html>`)
+		})
+
+		mux.HandleFunc("/frame-child", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<body>
+<p id="child-text">Inside the child frame</p>
+<iframe srcdoc="<p>Inside the nested frame</p>"></iframe>
+</body>
+</html>`)
+		})
+
+		// Page rendering its content inside open shadow roots, both scripted and declarative
+		mux.HandleFunc("/shadow", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Shadow DOM</title></head>
+<body>
+<product-card><span slot="name">Slotted product name</span></product-card>
+<declarative-card>
+<template shadowrootmode="open"><p>Declarative shadow text</p></template>
+</declarative-card>
+<script>
+customElements.define('product-card', class extends HTMLElement {
+	connectedCallback() {
+		this.attachShadow({ mode: 'open' }).innerHTML =
+			'<h2><slot name="name"></slot></h2><p class="price">Shadow price 42</p>';
+	}
+});
+</script>
+</body>
+</html>`)
+		})
+
+		// Page that logs at several levels, including repeated messages
+		mux.HandleFunc("/console-noise", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Console Noise</title></head>
+<body>
+<h1>Console Noise</h1>
+<script>
+console.debug('debug detail');
+console.log('polling api');
+console.log('polling api');
+console.log('polling api');
+console.warn('api slow');
+console.error('render failed');
+</script>
+</body>
+</html>`)
+		})
+
+		// Page that throws during load and leaves a promise rejection unhandled
+		mux.HandleFunc("/js-errors", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>JS Errors</title></head>
+<body>
+<h1>JS Errors</h1>
+<script>
+function explode() {
+	throw new Error('load-time explosion');
+}
+setTimeout(explode, 0);
+Promise.reject(new Error('rejected on purpose'));
+</script>
+</body>
+</html>`)
+		})
+
+		// Page throwing errors whose messages are empty or contained in one another
+		mux.HandleFunc("/js-errors-similar", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Similar Errors</title></head>
+<body>
+<script>
+setTimeout(function() { throw new Error('boom'); }, 0);
+setTimeout(function() { throw new Error('boom again'); }, 0);
+setTimeout(function() { throw new Error(''); }, 0);
+</script>
+</body>
+</html>`)
+		})
+
 		// Page with LiveView simulation
 		mux.HandleFunc("/liveview", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/html")
@@ -110,6 +608,37 @@ func startTestServer() {
 </html>`)
 		})
 
+		// WebSocket echo endpoint, and a page that only shows content once the socket answers
+		mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				kind, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(kind, append([]byte("echo: "), message...))
+			}
+		})
+		mux.HandleFunc("/socket", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head><title>Socket</title></head>
+<body>
+<div id="out">Connecting</div>
+<script>
+var socket = new WebSocket('ws://' + location.host + '/ws');
+socket.onopen = function() { socket.send('hello socket'); };
+socket.onmessage = function(event) { document.getElementById('out').textContent = event.data; };
+</script>
+</body>
+</html>`)
+		})
+
 		// Start server on port 9999
 		go http.ListenAndServe(":9999", mux)
 		testServerURL = "http://localhost:9999"
@@ -370,51 +899,206 @@ func TestFormHandling(t *testing.T) {
 	}
 }
 
-func TestHelpCommand(t *testing.T) {
-	t.Parallel()
+func TestFormUpload(t *testing.T) {
 	setupTest(t)
-	
-	stdout, stderr, err := runWeb("--help")
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(path, []byte("quarterly numbers"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := runWeb(testServerURL+"/upload", "--form", "upload-form", "--input", "title", "--value", "Q3", "--upload", "attachment", path)
 	if err != nil {
-		t.Fatalf("Help command failed: %v\nStderr: %s", err, stderr)
+		t.Fatalf("Upload failed: %v\nStderr: %s", err, stderr)
 	}
-	
-	expectedStrings := []string{
-		"Usage: web",
-		"--help",
-		"--raw", 
-		"--screenshot",
-		"--js",
-		"--profile",
-		"Phoenix LiveView Support:",
+	if !strings.Contains(stdout, "Received report.txt (17 bytes) from Q3") {
+		t.Errorf("Expected the server to receive the file. Got: %s", stdout)
 	}
-	
-	for _, expected := range expectedStrings {
-		if !strings.Contains(stdout, expected) {
-			t.Errorf("Help output missing expected string '%s'", expected)
-		}
+
+	_, stderr, err = runWeb(testServerURL+"/upload", "--form", "upload-form", "--upload", "attachment", "does-not-exist.txt")
+	if err == nil || !strings.Contains(stderr, "upload attachment: file not found") {
+		t.Errorf("Expected a missing upload file to be rejected. Stderr: %s", stderr)
 	}
 }
 
-func TestPhoenixLiveViewDetection(t *testing.T) {
+func TestLiveViewUpload(t *testing.T) {
 	setupTest(t)
-	
-	stdout, stderr, err := runWeb(
-		testServerURL+"/liveview",
-		"--js", `
-			const div = document.querySelector('[data-phx-session]');
-			if (div) {
-				console.log('LiveView element detected');
-			}
-		`,
-		"--truncate-after", "200",
-	)
-	if err != nil {
-		t.Fatalf("Phoenix LiveView detection test failed: %v\nStderr: %s", err, stderr)
-	}
-	
-	if !strings.Contains(stdout, "LiveView element detected") {
-		t.Errorf("LiveView detection failed. Got: %s", stdout)
+
+	path := filepath.Join(t.TempDir(), "avatar.png")
+	if err := os.WriteFile(path, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server consumes the entries on submit, leaving the refs empty, without the done
+	// refs ever being seen; that is a finished upload, not a timeout
+	for _, page := range []string{"/live-upload", "/live-upload?auto=1"} {
+		stdout, stderr, err := runWeb(testServerURL+page, "--form", "avatar-form", "--upload", "avatar", path, "--wait-timeout", "5s")
+		if err != nil {
+			t.Fatalf("LiveView upload on %s failed: %v\nStderr: %s", page, err, stderr)
+		}
+		if !strings.Contains(stderr, "LiveView upload of avatar completed") {
+			t.Errorf("Expected the upload on %s to be reported complete. Stderr: %s", page, stderr)
+		}
+		if !strings.Contains(stdout, "Saved avatar.png") {
+			t.Errorf("Expected the form on %s to be submitted with the file. Got: %s", page, stdout)
+		}
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	setupTest(t)
+
+	dir := t.TempDir()
+	stdout, stderr, err := runWeb(testServerURL+"/reports", "--click", "#export", "--download-dir", dir, "--json")
+	if err != nil {
+		t.Fatalf("Download failed: %v\nStderr: %s", err, stderr)
+	}
+	var result struct {
+		Downloads []struct {
+			Name     string `json:"name"`
+			Size     int64  `json:"size"`
+			MIMEType string `json:"mime_type"`
+		} `json:"downloads"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(result.Downloads) != 1 || result.Downloads[0].Name != "report.csv" || result.Downloads[0].Size != 26 || !strings.HasPrefix(result.Downloads[0].MIMEType, "text/csv") {
+		t.Errorf("Expected report.csv to be reported. Got: %+v", result.Downloads)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "report.csv")); err != nil || !strings.Contains(string(data), "feb,20") {
+		t.Errorf("Expected report.csv in the download directory: %v", err)
+	}
+
+	// Types Firefox has no handler for are saved without a prompt too
+	dir = t.TempDir()
+	_, stderr, err = runWeb(testServerURL+"/reports", "--click", "#export-ndjson", "--download-dir", dir)
+	if err != nil {
+		t.Fatalf("Download failed: %v\nStderr: %s", err, stderr)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "report.ndjson")); err != nil || !strings.Contains(string(data), `"total":10`) {
+		t.Errorf("Expected report.ndjson in the download directory: %v", err)
+	}
+}
+
+func TestFormFieldTypes(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/fields", "--form", "fields-form",
+		"--input", "country", "--value", "Netherlands",
+		"--input", "bio", "--value", "Hello there",
+		"--input", "terms", "--value", "true",
+		"--input", "plan", "--value", "pro",
+		"--input", "#editor", "--value", "Draft text",
+	)
+	if err != nil {
+		t.Fatalf("Filling fields failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "country=NL; bio=Hello there; terms=yes; plan=pro; editor=Draft text") {
+		t.Errorf("Expected every field type to be set. Got: %s", stdout)
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/fields", "--form", "fields-form", "--input", "country", "--value", "France")
+	if err == nil || !strings.Contains(stderr, `no option "France"`) || !strings.Contains(stderr, `"NL"`) {
+		t.Errorf("Expected an error listing the select's options. Stderr: %s", stderr)
+	}
+}
+
+func TestFormLocating(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/forms", "--form", "user[profile]", "--input", "user[name]", "--value", "Ada")
+	if err != nil {
+		t.Fatalf("Filling the form by id failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "profile: Ada") {
+		t.Errorf("Expected the form with a bracketed id to be submitted. Got: %s", stdout)
+	}
+
+	for _, option := range [][]string{{"--form-action", "/session"}, {"--form-name", "login"}, {"--form-selector", "form[name=login]"}} {
+		stdout, stderr, err = runWeb(testServerURL+"/forms", option[0], option[1], "--input", "email", "--value", "ada@example.com")
+		if err != nil {
+			t.Fatalf("Filling the form with %s failed: %v\nStderr: %s", option[0], err, stderr)
+		}
+		if !strings.Contains(stdout, "login: ada@example.com") {
+			t.Errorf("Expected %s to pick the login form. Got: %s", option[0], stdout)
+		}
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/forms", "--input", "email", "--value", "ada@example.com")
+	if err == nil || !strings.Contains(stderr, "2 forms") || !strings.Contains(stderr, `name="login"`) {
+		t.Errorf("Expected an error listing the page's forms. Stderr: %s", stderr)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/fields", "--input", "bio", "--value", "Only form")
+	if err != nil {
+		t.Fatalf("Filling the page's only form failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "bio=Only form") {
+		t.Errorf("Expected the only form to be used. Got: %s", stdout)
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/fields", "--input", "signup", "--value", "x")
+	if err == nil || !strings.Contains(stderr, `no field named "signup" in the page's only form`) {
+		t.Errorf("Expected an error naming the field that was not found. Stderr: %s", stderr)
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/forms", "--form-name", "signup", "--input", "email", "--value", "x")
+	if err == nil || !strings.Contains(stderr, `no form named "signup"`) {
+		t.Errorf("Expected an error naming the form that was not found. Stderr: %s", stderr)
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/forms", "--form", "login", "--form-name", "login")
+	if err == nil || !strings.Contains(stderr, "use only one of") {
+		t.Errorf("Expected an error for two form options. Stderr: %s", stderr)
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	t.Parallel()
+	setupTest(t)
+	
+	stdout, stderr, err := runWeb("--help")
+	if err != nil {
+		t.Fatalf("Help command failed: %v\nStderr: %s", err, stderr)
+	}
+	
+	expectedStrings := []string{
+		"Usage: web",
+		"--help",
+		"--raw", 
+		"--screenshot",
+		"--js",
+		"--profile",
+		"Phoenix LiveView Support:",
+	}
+	
+	for _, expected := range expectedStrings {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("Help output missing expected string '%s'", expected)
+		}
+	}
+}
+
+func TestPhoenixLiveViewDetection(t *testing.T) {
+	setupTest(t)
+	
+	stdout, stderr, err := runWeb(
+		testServerURL+"/liveview",
+		"--js", `
+			const div = document.querySelector('[data-phx-session]');
+			if (div) {
+				console.log('LiveView element detected');
+			}
+		`,
+		"--truncate-after", "200",
+	)
+	if err != nil {
+		t.Fatalf("Phoenix LiveView detection test failed: %v\nStderr: %s", err, stderr)
+	}
+	
+	if !strings.Contains(stdout, "LiveView element detected") {
+		t.Errorf("LiveView detection failed. Got: %s", stdout)
 	}
 }
 
@@ -610,4 +1294,1177 @@ func TestNonLiveViewPageDoesNotTriggerLiveViewLogic(t *testing.T) {
 	if strings.Contains(stdout, "Phoenix LiveView connected") {
 		t.Errorf("Regular page should not show LiveView connection message. Got: %s", stdout)
 	}
-}
\ No newline at end of file
+}
+// holdProfileLock takes the profile's advisory lock from the test process, as a competing run would
+func holdProfileLock(t *testing.T, profile string, mode int) func() {
+	homeDir, _ := os.UserHomeDir()
+	profileDir := filepath.Join(homeDir, ".web-firefox", "profiles", profile)
+	os.MkdirAll(profileDir, 0755)
+
+	file, err := os.OpenFile(profileDir+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open profile lock: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), mode); err != nil {
+		t.Fatalf("Failed to lock profile: %v", err)
+	}
+	file.WriteAt([]byte(fmt.Sprintf("%d", os.Getpid())), 0)
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+		os.RemoveAll(profileDir)
+		os.Remove(profileDir + ".lock")
+	}
+}
+
+func TestProfileLockReportsHolder(t *testing.T) {
+	setupTest(t)
+
+	profile := fmt.Sprintf("test-lock-%d", time.Now().UnixNano())
+	release := holdProfileLock(t, profile, syscall.LOCK_EX)
+	defer release()
+
+	start := time.Now()
+	_, stderr, err := runWeb("--profile", profile, testServerURL, "--lock-wait", "1s")
+	if err == nil {
+		t.Fatalf("Expected run against a locked profile to fail")
+	}
+
+	if !strings.Contains(stderr, fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("Expected lock error to name holder pid %d. Got: %s", os.Getpid(), stderr)
+	}
+
+	if time.Since(start) < time.Second {
+		t.Errorf("Expected --lock-wait to wait before giving up")
+	}
+}
+
+func TestProfileReadonlyUsesSnapshot(t *testing.T) {
+	setupTest(t)
+
+	profile := fmt.Sprintf("test-readonly-%d", time.Now().UnixNano())
+	release := holdProfileLock(t, profile, syscall.LOCK_SH)
+	defer release()
+
+	// A shared holder blocks normal runs but not read-only ones
+	if _, _, err := runWeb("--profile", profile, testServerURL); err == nil {
+		t.Errorf("Expected normal run to fail while profile is share-locked")
+	}
+
+	_, stderr, err := runWeb(
+		"--profile", profile, "--profile-readonly",
+		testServerURL,
+		"--js", "localStorage.setItem('readonly-key', 'written');",
+	)
+	if err != nil {
+		t.Fatalf("Read-only run failed: %v\nStderr: %s", err, stderr)
+	}
+
+	stdout, stderr, err := runWeb(
+		"--profile", profile, "--profile-readonly",
+		testServerURL,
+		"--js", "console.log('Readonly sees:', localStorage.getItem('readonly-key'));",
+	)
+	if err != nil {
+		t.Fatalf("Second read-only run failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "Readonly sees: null") {
+		t.Errorf("Read-only run leaked writes into the profile. Got: %s", stdout)
+	}
+}
+
+func TestProfileReadonlyWhileSessionRuns(t *testing.T) {
+	setupTest(t)
+
+	// A running session holds the profile exclusively; the read-only run the busy-profile
+	// error recommends must still go ahead against a copy
+	profile := fmt.Sprintf("test-readonly-busy-%d", time.Now().UnixNano())
+	release := holdProfileLock(t, profile, syscall.LOCK_EX)
+	defer release()
+
+	_, stderr, err := runWeb("--profile", profile, testServerURL)
+	if err == nil || !strings.Contains(stderr, "--profile-readonly") {
+		t.Fatalf("Expected normal run to fail and suggest --profile-readonly. Stderr: %s", stderr)
+	}
+
+	stdout, stderr, err := runWeb("--profile", profile, "--profile-readonly", testServerURL)
+	if err != nil {
+		t.Fatalf("Read-only run failed while the profile was in use: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stderr, "may be inconsistent") {
+		t.Errorf("Expected a warning that the copy may be inconsistent. Stderr: %s", stderr)
+	}
+	if !strings.Contains(stdout, "Test Page") {
+		t.Errorf("Expected page content from the read-only run. Got: %s", stdout)
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	setupTest(t)
+
+	// Two real runs at once: one pair sharing a profile (the second waits for the lock),
+	// and one pair on different profiles, each with its own geckodriver
+	shared := fmt.Sprintf("test-concurrent-%d", time.Now().UnixNano())
+	runs := [][]string{
+		{"--profile", shared, "--lock-wait", "60s", testServerURL},
+		{"--profile", shared, "--lock-wait", "60s", testServerURL},
+		{"--profile", shared + "-a", testServerURL},
+		{"--profile", shared + "-b", testServerURL},
+	}
+	defer func() {
+		homeDir, _ := os.UserHomeDir()
+		for _, name := range []string{shared, shared + "-a", shared + "-b"} {
+			profileDir := filepath.Join(homeDir, ".web-firefox", "profiles", name)
+			os.RemoveAll(profileDir)
+			os.Remove(profileDir + ".lock")
+		}
+	}()
+
+	for _, pair := range [][][]string{runs[:2], runs[2:]} {
+		var wg sync.WaitGroup
+		errs := make([]error, len(pair))
+		outputs := make([]string, len(pair))
+		for i, args := range pair {
+			wg.Add(1)
+			go func(i int, args []string) {
+				defer wg.Done()
+				var stderr string
+				outputs[i], stderr, errs[i] = runWeb(args...)
+				if errs[i] != nil {
+					errs[i] = fmt.Errorf("%v\nStderr: %s", errs[i], stderr)
+				}
+			}(i, args)
+		}
+		wg.Wait()
+
+		for i := range pair {
+			if errs[i] != nil {
+				t.Errorf("Concurrent run %v failed: %v", pair[i], errs[i])
+			} else if !strings.Contains(outputs[i], "Test Page") {
+				t.Errorf("Concurrent run %v returned unexpected output: %s", pair[i], outputs[i])
+			}
+		}
+	}
+}
+
+func TestSecretValueFromEnvIsRedacted(t *testing.T) {
+	setupTest(t)
+
+	secret := fmt.Sprintf("hunter2-%d", time.Now().UnixNano())
+	cmd := exec.Command("./"+testBinary,
+		testServerURL+"/echo-form",
+		"--form", "echo-form",
+		"--input", "username", "--value", "alice",
+		"--input", "password", "--value-env", "WEB_TEST_SECRET",
+	)
+	cmd.Env = append(os.Environ(), "WEB_TEST_SECRET="+secret)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Secret form submission failed: %v\nOutput: %s", err, output)
+	}
+
+	if strings.Contains(string(output), secret) {
+		t.Errorf("Secret value leaked into output: %s", output)
+	}
+
+	if !strings.Contains(string(output), "submitted password: [REDACTED]") {
+		t.Errorf("Expected redacted password in console output. Got: %s", output)
+	}
+}
+
+func TestSecretValueFromStdin(t *testing.T) {
+	setupTest(t)
+
+	secret := fmt.Sprintf("stdin-secret-%d", time.Now().UnixNano())
+	cmd := exec.Command("./"+testBinary,
+		testServerURL+"/echo-form",
+		"--form", "echo-form",
+		"--input", "password", "--value-stdin",
+	)
+	cmd.Stdin = strings.NewReader(secret + "\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Stdin secret form submission failed: %v\nOutput: %s", err, output)
+	}
+
+	if strings.Contains(string(output), secret) {
+		t.Errorf("Secret value leaked into output: %s", output)
+	}
+}
+
+func TestValueStdinRejectedInREPL(t *testing.T) {
+	setupTest(t)
+
+	cmd := exec.Command("./"+testBinary, "repl", testServerURL+"/echo-form",
+		"--form", "echo-form",
+		"--input", "password", "--value-stdin",
+	)
+	cmd.Stdin = strings.NewReader("quit\n")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("Expected --value-stdin to be rejected by web repl. Output: %s", output)
+	}
+	if !strings.Contains(string(output), "stdin carries REPL commands") {
+		t.Errorf("Expected an error explaining stdin is used for commands. Got: %s", output)
+	}
+}
+
+func TestMissingSecretEnvFails(t *testing.T) {
+	setupTest(t)
+
+	_, stderr, err := runWeb(
+		testServerURL+"/echo-form",
+		"--form", "echo-form",
+		"--input", "password", "--value", "@secret:env:WEB_TEST_UNSET_VARIABLE",
+	)
+	if err == nil {
+		t.Fatalf("Expected missing secret env var to fail")
+	}
+
+	if !strings.Contains(stderr, "WEB_TEST_UNSET_VARIABLE is not set") {
+		t.Errorf("Expected error naming the missing variable. Got: %s", stderr)
+	}
+}
+
+func TestWaitForSelector(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/delayed", "--wait-for", "#loaded", "--wait-gone", ".spinner")
+	if err != nil {
+		t.Fatalf("Wait for selector failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "Delayed content arrived") {
+		t.Errorf("Expected delayed content after waiting. Got: %s", stdout)
+	}
+
+	if strings.Contains(stdout, "Loading...") {
+		t.Errorf("Spinner should be gone before capture. Got: %s", stdout)
+	}
+}
+
+func TestWaitForTextAndJS(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(
+		testServerURL+"/delayed",
+		"--wait-for-text", "Delayed content",
+		"--wait-for-js", "document.querySelectorAll('#results p').length === 1",
+	)
+	if err != nil {
+		t.Fatalf("Wait for text/JS failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "Delayed content arrived") {
+		t.Errorf("Expected delayed content after waiting. Got: %s", stdout)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	setupTest(t)
+
+	_, stderr, err := runWeb(testServerURL+"/delayed", "--wait-for", "#never-appears", "--wait-timeout", "1s")
+	if err == nil {
+		t.Fatalf("Expected wait for missing selector to fail")
+	}
+
+	if !strings.Contains(stderr, "waiting for selector \"#never-appears\"") {
+		t.Errorf("Expected timeout error naming the selector. Got: %s", stderr)
+	}
+}
+
+func TestWaitUntilNetworkIdle(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/spa", "--wait-until", "networkidle")
+	if err != nil {
+		t.Fatalf("Network idle wait failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "Network idle") {
+		t.Errorf("Expected network idle status message. Got: %s", stdout)
+	}
+
+	if !strings.Contains(stdout, "Fetched data rendered") {
+		t.Errorf("Expected fetched data in output. Got: %s", stdout)
+	}
+
+	// A request already pending when the page finishes loading must still be waited for
+	stdout, stderr, err = runWeb(testServerURL+"/spa-early", "--wait-until", "networkidle", "--idle-time", "200ms")
+	if err != nil {
+		t.Fatalf("Network idle wait failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Fetched data rendered") {
+		t.Errorf("Expected data from a request started during page load. Got: %s", stdout)
+	}
+}
+
+func TestWaitStable(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/rendering", "--wait-stable", "500")
+	if err != nil {
+		t.Fatalf("DOM stability wait failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "DOM stable") {
+		t.Errorf("Expected DOM stable status message. Got: %s", stdout)
+	}
+
+	if !strings.Contains(stdout, "Final item rendered") {
+		t.Errorf("Expected all items rendered before capture. Got: %s", stdout)
+	}
+}
+
+func TestHARExport(t *testing.T) {
+	setupTest(t)
+
+	harFile := fmt.Sprintf("test-har-%d.har", time.Now().UnixNano())
+	defer os.Remove(harFile)
+
+	stdout, stderr, err := runWeb(testServerURL+"/spa", "--har", harFile, "--wait-until", "networkidle")
+	if err != nil {
+		t.Fatalf("HAR export failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "HAR saved to "+harFile) {
+		t.Errorf("HAR save message not found in output. Got: %s", stdout)
+	}
+
+	data, err := os.ReadFile(harFile)
+	if err != nil {
+		t.Fatalf("HAR file not created: %v", err)
+	}
+
+	var har struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method string
+					URL    string
+				}
+				Response struct {
+					Status  int
+					Content struct {
+						Text string
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("HAR file is not valid JSON: %v", err)
+	}
+
+	if har.Log.Version != "1.2" {
+		t.Errorf("Expected HAR version 1.2, got %q", har.Log.Version)
+	}
+
+	foundPage, foundAPI := false, false
+	for _, entry := range har.Log.Entries {
+		if entry.Request.URL == testServerURL+"/spa" && entry.Response.Status == 200 {
+			foundPage = true
+		}
+		if entry.Request.URL == testServerURL+"/api/data" && strings.Contains(entry.Response.Content.Text, "Fetched data rendered") {
+			foundAPI = true
+		}
+	}
+	if !foundPage {
+		t.Errorf("HAR missing main document entry")
+	}
+	if !foundAPI {
+		t.Errorf("HAR missing fetch entry with response body")
+	}
+}
+
+func TestHARLeavesNoCAInProfile(t *testing.T) {
+	setupTest(t)
+
+	profile := fmt.Sprintf("test-har-ca-%d", time.Now().UnixNano())
+	homeDir, _ := os.UserHomeDir()
+	profileDir := filepath.Join(homeDir, ".web-firefox", "profiles", profile)
+	defer os.RemoveAll(profileDir)
+	defer os.Remove(profileDir + ".lock")
+
+	harFile := fmt.Sprintf("test-har-ca-%d.har", time.Now().UnixNano())
+	defer os.Remove(harFile)
+
+	// A CA left trusted by a killed run is removed before the new one is added
+	os.MkdirAll(profileDir, 0755)
+	stale := exec.Command("sh", "-c", `certutil -N --empty-password -d sql:"$1" &&
+		openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout /dev/null -subj "/CN=stale" -days 1 2>/dev/null |
+		certutil -A -n "web HAR recording CA" -t C,, -a -d sql:"$1"`, "sh", profileDir)
+	if output, err := stale.CombinedOutput(); err != nil {
+		t.Skipf("Could not plant a stale CA (needs certutil and openssl): %v\n%s", err, output)
+	}
+
+	if _, stderr, err := runWeb("--profile", profile, testServerURL, "--har", harFile); err != nil {
+		t.Fatalf("HAR run failed: %v\nStderr: %s", err, stderr)
+	}
+
+	output, err := exec.Command("certutil", "-L", "-d", "sql:"+profileDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Could not list the profile's certificates: %v\n%s", err, output)
+	}
+	if strings.Contains(string(output), "web HAR recording CA") {
+		t.Errorf("Expected no HAR CA to stay trusted after the run. Got: %s", output)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(profileDir, "*.pem")); len(matches) > 0 {
+		t.Errorf("Expected no CA files in the profile. Got: %v", matches)
+	}
+}
+
+func TestHARPassesWebSocketsThrough(t *testing.T) {
+	setupTest(t)
+
+	harFile := fmt.Sprintf("test-har-ws-%d.har", time.Now().UnixNano())
+	defer os.Remove(harFile)
+
+	stdout, stderr, err := runWeb(testServerURL+"/socket", "--har", harFile, "--wait-for-text", "echo: hello socket")
+	if err != nil {
+		t.Fatalf("WebSocket page under --har failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "echo: hello socket") {
+		t.Errorf("Expected the WebSocket reply in output. Got: %s", stdout)
+	}
+
+	data, err := os.ReadFile(harFile)
+	if err != nil {
+		t.Fatalf("HAR file not created: %v", err)
+	}
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					URL string `json:"url"`
+				} `json:"request"`
+				Response struct {
+					Status int `json:"status"`
+				} `json:"response"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("Invalid HAR JSON: %v", err)
+	}
+	handshake := false
+	for _, entry := range har.Log.Entries {
+		if strings.HasSuffix(entry.Request.URL, "/ws") && entry.Response.Status == http.StatusSwitchingProtocols {
+			handshake = true
+		}
+	}
+	if !handshake {
+		t.Errorf("Expected the WebSocket handshake to be recorded with status 101")
+	}
+}
+
+func TestHARRejectsUntrustedUpstreamCertificate(t *testing.T) {
+	setupTest(t)
+
+	// A self-signed server the system roots don't trust
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body>Untrusted content</body></html>")
+	}))
+	defer server.Close()
+
+	harFile := fmt.Sprintf("test-har-tls-%d.har", time.Now().UnixNano())
+	defer os.Remove(harFile)
+
+	stdout, stderr, _ := runWeb(server.URL, "--har", harFile)
+	if strings.Contains(stdout, "Untrusted content") {
+		t.Errorf("Expected the proxy to refuse an untrusted certificate. Got: %s", stdout)
+	}
+	if !strings.Contains(stdout+stderr, "certificate") || !strings.Contains(stdout+stderr, "rejected") {
+		t.Errorf("Expected the certificate rejection to be reported. Stdout: %s\nStderr: %s", stdout, stderr)
+	}
+}
+
+func TestHTTPStatusAndRedirectChain(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/redirect")
+	if err != nil {
+		t.Fatalf("Redirected scraping failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "Status: 200 OK") {
+		t.Errorf("Expected status line in output header. Got: %s", stdout)
+	}
+
+	expected := fmt.Sprintf("Redirects: %s/redirect (302) -> %s/", testServerURL, testServerURL)
+	if !strings.Contains(stdout, expected) {
+		t.Errorf("Expected redirect chain %q in output header. Got: %s", expected, stdout)
+	}
+
+	if !strings.Contains(stdout, "Content-Type: text/html") {
+		t.Errorf("Expected Content-Type header in output header. Got: %s", stdout)
+	}
+}
+
+func TestFailOnHTTPError(t *testing.T) {
+	setupTest(t)
+
+	// Without the flag, error pages are scraped normally
+	stdout, stderr, err := runWeb(testServerURL + "/missing")
+	if err != nil {
+		t.Fatalf("Scraping error page failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Status: 404 Not Found") {
+		t.Errorf("Expected 404 status in output header. Got: %s", stdout)
+	}
+
+	// The browser reports the host in lowercase; the status must still be found
+	stdout, stderr, err = runWeb(strings.Replace(testServerURL, "localhost", "LOCALHOST", 1) + "/missing")
+	if err != nil {
+		t.Fatalf("Scraping error page failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Status: 404 Not Found") {
+		t.Errorf("Expected 404 status for an uppercase host. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/missing", "--fail-on-http-error")
+	if err == nil {
+		t.Fatalf("Expected --fail-on-http-error to exit non-zero on 404")
+	}
+	if !strings.Contains(stdout, "Custom Not Found Page") {
+		t.Errorf("Expected page content to still be printed. Got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "HTTP 404") {
+		t.Errorf("Expected HTTP status in error. Got: %s", stderr)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/redirect", "--json", "--js", "console.log('json console')")
+	if err != nil {
+		t.Fatalf("JSON output failed: %v\nStderr: %s", err, stderr)
+	}
+
+	var result struct {
+		URL       string
+		FinalURL  string `json:"final_url"`
+		Status    int
+		Headers   map[string]string
+		Redirects []struct {
+			URL    string
+			Status int
+		}
+		Content string
+		Console []string
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\nOutput: %s", err, stdout)
+	}
+
+	if result.Status != 200 || result.FinalURL != testServerURL+"/" {
+		t.Errorf("Unexpected status/final URL: %d %s", result.Status, result.FinalURL)
+	}
+	if len(result.Redirects) != 1 || result.Redirects[0].Status != 302 {
+		t.Errorf("Expected one 302 redirect, got %+v", result.Redirects)
+	}
+	if !strings.Contains(result.Content, "Test Page") {
+		t.Errorf("Expected page content in JSON. Got: %s", result.Content)
+	}
+	if len(result.Console) == 0 || !strings.Contains(strings.Join(result.Console, "\n"), "json console") {
+		t.Errorf("Expected console messages in JSON. Got: %v", result.Console)
+	}
+}
+
+func TestConsoleCapturedDuringPageLoad(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/load-log")
+	if err != nil {
+		t.Fatalf("Page load logging test failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "[LOG] logged during page load") {
+		t.Errorf("Console message logged during page load was lost. Got: %s", stdout)
+	}
+}
+
+func TestConsoleSurvivesNavigation(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(
+		testServerURL+"/button-click",
+		"--js", `console.log('logged before navigation'); window.location.href = '/load-log';`,
+	)
+	if err != nil {
+		t.Fatalf("Navigation logging test failed: %v\nStderr: %s", err, stderr)
+	}
+
+	for _, expected := range []string{"[LOG] logged before navigation", "[LOG] logged during page load"} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("Expected %q in console output. Got: %s", expected, stdout)
+		}
+	}
+}
+
+func TestConsoleTimestampsAndRepeats(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/console-noise")
+	if err != nil {
+		t.Fatalf("Console formatting test failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !regexp.MustCompile(`\[\+\d+ms\] \[LOG\] polling api \(.*console-noise:\d+\) \(x3\)`).MatchString(stdout) {
+		t.Errorf("Expected timestamped, collapsed console line with source location. Got: %s", stdout)
+	}
+}
+
+func TestConsoleFiltering(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/console-noise", "--console-level", "warn", "--console-grep", "api")
+	if err != nil {
+		t.Fatalf("Console filtering test failed: %v\nStderr: %s", err, stderr)
+	}
+
+	if !strings.Contains(stdout, "[WARNING] api slow") {
+		t.Errorf("Expected warning matching the pattern. Got: %s", stdout)
+	}
+	for _, unexpected := range []string{"polling api", "render failed", "debug detail"} {
+		if strings.Contains(stdout, unexpected) {
+			t.Errorf("Did not expect %q in filtered console output. Got: %s", unexpected, stdout)
+		}
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/console-noise", "--console-limit", "1")
+	if err != nil {
+		t.Fatalf("Console limit test failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "more messages omitted") {
+		t.Errorf("Expected omitted messages note. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/console-noise", "--no-console")
+	if err != nil {
+		t.Fatalf("No console test failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "CONSOLE OUTPUT:") {
+		t.Errorf("Expected console output to be omitted. Got: %s", stdout)
+	}
+}
+
+func TestNetworkErrorsSection(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/broken-assets", "--wait-for", "body[data-fetched]")
+	if err != nil {
+		t.Fatalf("Network error capture failed: %v\nStderr: %s", err, stderr)
+	}
+
+	expected := []string{
+		"NETWORK ERRORS:",
+		"GET " + testServerURL + "/missing-image.png -> 404 Not Found",
+		"GET " + testServerURL + "/missing -> 404 Not Found",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(stdout, exp) {
+			t.Errorf("Expected %q in output. Got: %s", exp, stdout)
+		}
+	}
+	if strings.Contains(stdout, "/broken-assets -> ") {
+		t.Errorf("Did not expect the main document in network errors. Got: %s", stdout)
+	}
+}
+
+func TestRunFlow(t *testing.T) {
+	setupTest(t)
+
+	flowPath := filepath.Join(t.TempDir(), "flow.yaml")
+	flow := `steps:
+  - goto: ${WEB_TEST_BASE}/echo-form
+  - fill: { selector: "input[name=username]", value: "${WEB_TEST_USER}" }
+  - js: "return document.querySelector('input[name=username]').value.length"
+  - goto: /button-click
+  - click: "#nav-button"
+  - assert: { url: /button-target }
+  - extract: { selector: h1, name: heading }
+`
+	if err := os.WriteFile(flowPath, []byte(flow), 0644); err != nil {
+		t.Fatalf("Failed to write flow: %v", err)
+	}
+
+	cmd := exec.Command("./"+testBinary, "run", flowPath)
+	cmd.Env = append(os.Environ(), "WEB_TEST_BASE="+testServerURL, "WEB_TEST_USER=flow-user-secret")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Flow run failed: %v\nOutput: %s", err, output)
+	}
+
+	expected := []string{
+		"7 of 7 steps run",
+		"[3] js",
+		"\n16\n",
+		"[5] click #nav-button",
+		"Navigated to " + testServerURL + "/button-target",
+		"[7] extract h1 as heading",
+		"Button Click Navigation Successful",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(output), exp) {
+			t.Errorf("Expected %q in flow output. Got: %s", exp, output)
+		}
+	}
+	if strings.Contains(string(output), "flow-user-secret") {
+		t.Errorf("Fill value from the environment leaked into output: %s", output)
+	}
+}
+
+func TestRunFlowStopsAtFailedAssert(t *testing.T) {
+	setupTest(t)
+
+	flowPath := filepath.Join(t.TempDir(), "flow.yaml")
+	flow := "steps:\n  - goto: " + testServerURL + "/\n  - assert: { text: Not On This Page }\n  - screenshot: never.png\n"
+	if err := os.WriteFile(flowPath, []byte(flow), 0644); err != nil {
+		t.Fatalf("Failed to write flow: %v", err)
+	}
+
+	stdout, stderr, err := runWeb("run", flowPath)
+	if err == nil {
+		t.Fatalf("Expected failed assertion to exit non-zero. Output: %s", stdout)
+	}
+	if !strings.Contains(stdout, "2 of 3 steps run") || !strings.Contains(stdout, "FAILED: assertion failed") {
+		t.Errorf("Expected report up to the failed step. Got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "flow stopped at step 2") {
+		t.Errorf("Expected failing step in error. Got: %s", stderr)
+	}
+	if _, err := os.Stat("never.png"); err == nil {
+		os.Remove("never.png")
+		t.Errorf("Steps after the failed assertion should not run")
+	}
+}
+
+func TestREPLKeepsPageState(t *testing.T) {
+	setupTest(t)
+
+	cmd := exec.Command("./"+testBinary, "repl", testServerURL+"/button-click")
+	cmd.Stdin = strings.NewReader(strings.Join([]string{
+		"js window.replState = 'kept'; return 1",
+		"js return window.replState",
+		"click #nav-button",
+		"text h1",
+		"back",
+		"click #does-not-exist",
+		"quit",
+	}, "\n"))
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("REPL failed: %v\nOutput: %s", err, output)
+	}
+
+	expected := []string{
+		"=== goto ok",
+		"=== js ok",
+		"kept",
+		"=== click ok",
+		"Button Click Navigation Successful",
+		"=== back ok",
+		"=== click error",
+		"=== end",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(output), exp) {
+			t.Errorf("Expected %q in REPL output. Got: %s", exp, output)
+		}
+	}
+}
+
+func TestREPLJSONResponses(t *testing.T) {
+	setupTest(t)
+
+	cmd := exec.Command("./"+testBinary, "repl", testServerURL, "--json")
+	cmd.Stdin = strings.NewReader("js return document.title\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("REPL failed: %v\nOutput: %s", err, output)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one JSON line per command. Got: %s", output)
+	}
+	var response struct {
+		Command string `json:"command"`
+		OK      bool   `json:"ok"`
+		Output  string `json:"output"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v\n%s", err, lines[1])
+	}
+	if response.Command != "js" || !response.OK || response.Output != "Test Page" {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+}
+
+func TestClickBySelectorAndText(t *testing.T) {
+	setupTest(t)
+
+	for _, args := range [][]string{
+		{"--click", "#nav-button"},
+		{"--click-text", "click me"},
+	} {
+		stdout, stderr, err := runWeb(append([]string{testServerURL + "/button-click"}, args...)...)
+		if err != nil {
+			t.Fatalf("Click %v failed: %v\nStderr: %s", args, err, stderr)
+		}
+		if !strings.Contains(stdout, "Button Click Navigation Successful") {
+			t.Errorf("Expected click %v to navigate to the target page. Got: %s", args, stdout)
+		}
+	}
+}
+
+func TestActionsRunInOrder(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(
+		testServerURL+"/button-click",
+		"--js", "console.log('before click')",
+		"--click", "#nav-button",
+		"--js", "console.log('after click on ' + location.pathname)",
+	)
+	if err != nil {
+		t.Fatalf("Ordered actions failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "before click") || !strings.Contains(stdout, "after click on /button-target") {
+		t.Errorf("Expected --js before and after the click to run on their pages. Got: %s", stdout)
+	}
+
+	_, stderr, err = runWeb(testServerURL+"/button-click", "--click-text", "No Such Button")
+	if err == nil {
+		t.Fatalf("Expected missing click target to fail")
+	}
+	if !strings.Contains(stderr, "No Such Button") {
+		t.Errorf("Expected the missing label in the error. Got: %s", stderr)
+	}
+}
+
+func TestScrollLoadsInfiniteFeed(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/infinite", "--scroll", "--scroll-delay", "500ms")
+	if err != nil {
+		t.Fatalf("Scrolling failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Feed item 20") {
+		t.Errorf("Expected all batches to be loaded by scrolling. Got: %s", stdout)
+	}
+	if !strings.Contains(stdout, "Scrolls: 4") {
+		t.Errorf("Expected scroll count in header (3 loading scrolls and one that found no growth). Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/infinite", "--scroll", "--max-scrolls", "1", "--scroll-delay", "500ms")
+	if err != nil {
+		t.Fatalf("Scrolling failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Feed item 11") || !strings.Contains(stdout, "Scrolls: 1") {
+		t.Errorf("Expected --max-scrolls to stop after one batch. Got: %s", stdout)
+	}
+}
+
+func TestPaginationFollowsNextLinks(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/list", "--next", "a[rel=next]")
+	if err != nil {
+		t.Fatalf("Pagination failed: %v\nStderr: %s", err, stderr)
+	}
+	for page := 1; page <= 3; page++ {
+		if !strings.Contains(stdout, fmt.Sprintf("--- Page %d: ", page)) || !strings.Contains(stdout, fmt.Sprintf("Result %d-b", page)) {
+			t.Errorf("Expected page %d under its own header. Got: %s", page, stdout)
+		}
+	}
+	if strings.Contains(stdout, "--- Page 4: ") || !strings.Contains(stdout, "Pages: 3") {
+		t.Errorf("Expected pagination to stop at the link back to the first page. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/list", "--next", "a[rel=next]", "--max-pages", "2")
+	if err != nil {
+		t.Fatalf("Pagination failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Result 3-a") || !strings.Contains(stdout, "Pages: 2") {
+		t.Errorf("Expected --max-pages to stop after two pages. Got: %s", stdout)
+	}
+}
+
+func TestCrawlWritesPagesAndManifest(t *testing.T) {
+	setupTest(t)
+
+	outDir := t.TempDir()
+	stdout, stderr, err := runWeb("crawl", testServerURL+"/site/", "--depth", "1", "--out", outDir)
+	if err != nil {
+		t.Fatalf("Crawl failed: %v\nStderr: %s", err, stderr)
+	}
+
+	for file, text := range map[string]string{"site/index.md": "Site home", "site/a.md": "Page A", "site/b.md": "Page B"} {
+		content, err := os.ReadFile(filepath.Join(outDir, file))
+		if err != nil || !strings.Contains(string(content), text) {
+			t.Errorf("Expected %s to contain %q: %v", file, text, err)
+		}
+	}
+	for _, file := range []string{"site/private.md", "site/b/deep.md"} {
+		if _, err := os.Stat(filepath.Join(outDir, file)); err == nil {
+			t.Errorf("Expected %s not to be crawled (robots.txt or depth limit)", file)
+		}
+	}
+	if !strings.Contains(stdout, "Crawled 3 page(s)") || !strings.Contains(stdout, "disallowed by robots.txt") {
+		t.Errorf("Expected crawl summary. Got: %s", stdout)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "index.json"))
+	if err != nil {
+		t.Fatalf("Expected manifest: %v", err)
+	}
+	var manifest struct {
+		Pages []struct {
+			URL    string `json:"url"`
+			File   string `json:"file"`
+			Title  string `json:"title"`
+			Status int    `json:"status"`
+		} `json:"pages"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest: %v\n%s", err, data)
+	}
+	if len(manifest.Pages) != 3 || manifest.Pages[0].File != filepath.FromSlash("site/index.md") || manifest.Pages[0].Title != "/site/" || manifest.Pages[0].Status != 200 {
+		t.Errorf("Unexpected manifest: %s", data)
+	}
+
+	// --exclude keeps matching links out of the crawl
+	outDir = t.TempDir()
+	stdout, stderr, err = runWeb("crawl", testServerURL+"/site/", "--depth", "2", "--exclude", "/site/b*", "--out", outDir)
+	if err != nil {
+		t.Fatalf("Crawl failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Crawled 2 page(s)") {
+		t.Errorf("Expected --exclude to skip page B. Got: %s", stdout)
+	}
+}
+
+func TestCrawlFromSitemap(t *testing.T) {
+	setupTest(t)
+
+	outDir := t.TempDir()
+	stdout, stderr, err := runWeb("crawl", "--sitemap", testServerURL+"/sitemap.xml", "--since", "2025-01-01", "--out", outDir)
+	if err != nil {
+		t.Fatalf("Sitemap crawl failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Crawled 2 page(s) from sitemap") {
+		t.Errorf("Expected the two pages modified since the date (or without lastmod). Got: %s", stdout)
+	}
+	for _, file := range []string{"site/b.md", "site/b/deep.md"} {
+		if _, err := os.Stat(filepath.Join(outDir, file)); err != nil {
+			t.Errorf("Expected %s to be written: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "site/a.md")); err == nil {
+		t.Errorf("Expected page A to be skipped by --since")
+	}
+
+	// A sitemap on another host (like www. or a CDN) still lists the crawl URL's pages
+	outDir = t.TempDir()
+	sitemapHost := strings.Replace(testServerURL, "localhost", "127.0.0.1", 1)
+	stdout, stderr, err = runWeb("crawl", testServerURL, "--sitemap", sitemapHost+"/sitemap.xml", "--since", "2025-01-01", "--out", outDir, "--json")
+	if err != nil {
+		t.Fatalf("Sitemap crawl from another host failed: %v\nStderr: %s", err, stderr)
+	}
+	var manifest struct {
+		StartURL string `json:"start_url"`
+		Sitemap  string `json:"sitemap"`
+		Pages    []struct {
+			URL string `json:"url"`
+		} `json:"pages"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &manifest); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(manifest.Pages) != 2 || manifest.StartURL != testServerURL+"/" || manifest.Sitemap != sitemapHost+"/sitemap.xml" {
+		t.Errorf("Expected the crawl URL's pages from the other host's sitemap. Got: %s", stdout)
+	}
+}
+
+func TestDialogHandling(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/dialogs", "--click", "#delete", "--dialog", "accept")
+	if err != nil {
+		t.Fatalf("Run with dialogs failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Deleted") {
+		t.Errorf("Expected the confirm dialog to be accepted. Got: %s", stdout)
+	}
+	if !strings.Contains(stdout, "DIALOGS:") || !strings.Contains(stdout, `alert "Welcome back" -> accept`) || !strings.Contains(stdout, `confirm "Delete this item?" -> accept`) {
+		t.Errorf("Expected dialogs to be listed. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/dialogs", "--click", "#delete")
+	if err != nil {
+		t.Fatalf("Run with dialogs failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Kept") {
+		t.Errorf("Expected the confirm dialog to be dismissed by default. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/dialogs", "--click", "#rename", "--dialog", "text=Report Q3", "--json")
+	if err != nil {
+		t.Fatalf("Run with dialogs failed: %v\nStderr: %s", err, stderr)
+	}
+	var result struct {
+		Content string `json:"content"`
+		Dialogs []struct {
+			Type   string `json:"type"`
+			Action string `json:"action"`
+			Text   string `json:"text"`
+		} `json:"dialogs"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, stdout)
+	}
+	if !strings.Contains(result.Content, "Name: Report Q3") {
+		t.Errorf("Expected the prompt to be answered with the text. Got: %s", result.Content)
+	}
+	if len(result.Dialogs) != 2 || result.Dialogs[1].Type != "prompt" || result.Dialogs[1].Text != "Report Q3" {
+		t.Errorf("Expected the alert and prompt in the dialogs list. Got: %+v", result.Dialogs)
+	}
+}
+
+func TestIframeContentInlined(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/with-frames")
+	if err != nil {
+		t.Fatalf("Frame capture failed: %v\nStderr: %s", err, stderr)
+	}
+
+	before := strings.Index(stdout, "Before the frame")
+	child := strings.Index(stdout, "Inside the child frame")
+	nested := strings.Index(stdout, "Inside the nested frame")
+	after := strings.Index(stdout, "After the frame")
+	if before < 0 || child < 0 || nested < 0 || after < 0 {
+		t.Fatalf("Expected page, child and nested frame content. Got: %s", stdout)
+	}
+	if !(before < child && child < nested && nested < after) {
+		t.Errorf("Expected frame content inline at the iframe's position. Got: %s", stdout)
+	}
+	if !strings.Contains(stdout, `--- iframe "widget" `+testServerURL+"/frame-child ---") {
+		t.Errorf("Expected labelled frame boundary. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/with-frames", "--frame-depth", "1")
+	if err != nil {
+		t.Fatalf("Frame capture failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Inside the nested frame") || !strings.Contains(stdout, "Inside the child frame") {
+		t.Errorf("Expected --frame-depth 1 to stop at the first level. Got: %s", stdout)
+	}
+
+	stdout, stderr, err = runWeb(testServerURL+"/with-frames", "--no-frames")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Inside the child frame") || !strings.Contains(stdout, "After the frame") {
+		t.Errorf("Expected --no-frames to leave iframe content out. Got: %s", stdout)
+	}
+}
+
+func TestJSInFrame(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(
+		testServerURL+"/with-frames",
+		"--frame", "#widget", "--js", "console.log('frame says: ' + document.getElementById('child-text').textContent)",
+	)
+	if err != nil {
+		t.Fatalf("Frame JS failed: %v\nStderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "frame says: Inside the child frame") {
+		t.Errorf("Expected --js to run inside the frame. Got: %s", stdout)
+	}
+}
+
+func TestShadowDOMFlattening(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL+"/shadow", "--shadow-dom", "--wait-for", "p.price")
+	if err != nil {
+		t.Fatalf("Shadow DOM capture failed: %v\nStderr: %s", err, stderr)
+	}
+	for _, expected := range []string{"Shadow price 42", "Slotted product name", "Declarative shadow text"} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("Expected %q in flattened output. Got: %s", expected, stdout)
+		}
+	}
+
+	stdout, stderr, err = runWeb(testServerURL + "/shadow")
+	if err != nil {
+		t.Fatalf("Scraping shadow page failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Contains(stdout, "Shadow price 42") {
+		t.Errorf("Expected shadow content only with --shadow-dom. Got: %s", stdout)
+	}
+}
+
+func TestUncaughtErrorsSection(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/js-errors")
+	if err != nil {
+		t.Fatalf("JS error capture failed: %v\nStderr: %s", err, stderr)
+	}
+
+	expected := []string{
+		"ERRORS:",
+		"[UNCAUGHT] Error: load-time explosion",
+		"[UNHANDLED REJECTION] Error: rejected on purpose",
+		"explode@",
+		testServerURL + "/js-errors:",
+	}
+	for _, e := range expected {
+		if !strings.Contains(stdout, e) {
+			t.Errorf("Expected %q in output. Got: %s", e, stdout)
+		}
+	}
+}
+
+func TestSimilarErrorsKeptApart(t *testing.T) {
+	setupTest(t)
+
+	stdout, stderr, err := runWeb(testServerURL + "/js-errors-similar")
+	if err != nil {
+		t.Fatalf("JS error capture failed: %v\nStderr: %s", err, stderr)
+	}
+	if strings.Count(stdout, "[UNCAUGHT]") != 3 {
+		t.Errorf("Expected three distinct uncaught errors. Got: %s", stdout)
+	}
+	if !strings.Contains(stdout, "[UNCAUGHT] Error: boom (") || !strings.Contains(stdout, "[UNCAUGHT] Error: boom again") {
+		t.Errorf("Expected both errors to be listed. Got: %s", stdout)
+	}
+}
+
+func TestFailOnJSError(t *testing.T) {
+	setupTest(t)
+
+	if _, stderr, err := runWeb(testServerURL, "--fail-on-js-error"); err != nil {
+		t.Fatalf("Page without errors should pass --fail-on-js-error: %v\nStderr: %s", err, stderr)
+	}
+
+	stdout, stderr, err := runWeb(testServerURL+"/js-errors", "--fail-on-js-error")
+	if err == nil {
+		t.Fatalf("Expected --fail-on-js-error to exit non-zero")
+	}
+	if !strings.Contains(stdout, "JS Errors") {
+		t.Errorf("Expected page content to still be printed. Got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "JavaScript error") {
+		t.Errorf("Expected JavaScript error reason on stderr. Got: %s", stderr)
+	}
+}
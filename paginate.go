@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tebeka/selenium"
+)
+
+const DEFAULT_MAX_PAGES = 10
+
+// capturedPage is the content of one page, with iframe content captured for inlining
+type capturedPage struct {
+	URL     string
+	Content string
+	Frames  map[string]string
+}
+
+// nextLinkScript returns the absolute href of a next element that is a plain link, or ""
+// for buttons and script links, which have to be clicked
+const nextLinkScript = `
+	var el = arguments[0];
+	var href = el.getAttribute('href');
+	if (el.tagName !== 'A' || !href || href.charAt(0) === '#' || /^javascript:/i.test(href)) return '';
+	return el.href;
+`
+
+// capturePageContent captures the current page as raw HTML, or with iframes for conversion
+func capturePageContent(wd selenium.WebDriver, config Config) (capturedPage, error) {
+	page := capturedPage{}
+	page.URL, _ = wd.CurrentURL()
+	var err error
+	if config.RawFlag {
+		page.Content, err = pageSource(wd)
+	} else {
+		page.Content, page.Frames, err = capturePage(wd, config.Frames)
+	}
+	if err != nil {
+		return page, fmt.Errorf("could not get page content: %v", err)
+	}
+	return page, nil
+}
+
+// followPagination follows the --next element from the current page until it is missing,
+// leads to an already visited URL, or --max-pages is reached. Each page gets the same waits
+// as the first. It returns the pages after the first and the number of scrolls performed.
+func followPagination(wd selenium.WebDriver, events *eventCapture, config Config, isLiveView bool, first capturedPage) ([]capturedPage, int) {
+	visited := map[string]bool{first.URL: true}
+	var pages []capturedPage
+	scrolls := 0
+
+	for len(pages)+1 < config.MaxPages {
+		elem, err := findElement(wd, config.NextSelector)
+		if err != nil {
+			statusf("No %s element found, stopping after %d page(s)", config.NextSelector, len(pages)+1)
+			break
+		}
+
+		currentURL, _ := wd.CurrentURL()
+		href := ""
+		if result, err := wd.ExecuteScript(nextLinkScript, []interface{}{elem}); err == nil {
+			href, _ = result.(string)
+		}
+
+		if href != "" {
+			// Plain links are followed directly, like any other navigation
+			if visited[href] {
+				statusf("Next link leads to already visited %s, stopping after %d page(s)", href, len(pages)+1)
+				break
+			}
+			statusf("Following next link to %s", href)
+			if err := wd.Get(href); err != nil {
+				statusf("Warning: Could not navigate to next page: %v", err)
+				break
+			}
+			isLiveView = detectLiveView(wd)
+		} else {
+			statusf("Clicking next element...")
+			if err := markDocument(wd); err != nil {
+				statusf("Warning: %v", err)
+				break
+			}
+			if err := elem.Click(); err != nil {
+				statusf("Warning: Could not click next element: %v", err)
+				break
+			}
+			if waitForNavigation(wd, currentURL, isLiveView, config) && !isLiveView {
+				isLiveView = detectLiveView(wd)
+			}
+		}
+
+		newURL, _ := wd.CurrentURL()
+		if visited[newURL] {
+			statusf("Next page has the already visited URL %s, stopping after %d page(s)", newURL, len(pages)+1)
+			break
+		}
+		visited[newURL] = true
+
+		if config.WaitUntil == "networkidle" {
+			waitForNetworkIdle(wd, events, config.IdleTime, config.WaitTimeout)
+		}
+		if err := waitForConditions(wd, config.Waits, config.WaitTimeout); err != nil {
+			statusf("Warning: %v, stopping after %d page(s)", err, len(pages)+1)
+			break
+		}
+		if config.WaitStable > 0 {
+			waitForDOMStable(wd, config.WaitStable, config.WaitTimeout)
+		}
+		if config.Scroll {
+			scrolls += scrollPage(wd, config.MaxScrolls, config.ScrollDelay, config.WaitTimeout)
+		}
+
+		page, err := capturePageContent(wd, config)
+		if err != nil {
+			statusf("Warning: %v, stopping after %d page(s)", err, len(pages)+1)
+			break
+		}
+		pages = append(pages, page)
+	}
+	if len(pages)+1 == config.MaxPages {
+		statusf("Stopped after --max-pages %d", config.MaxPages)
+	}
+	return pages, scrolls
+}
+
+// pagesOutput converts each page (unless raw) and concatenates them under per-page headers;
+// a single page is returned without a header. It also returns the full length of the
+// converted text before cleaning, for the truncation notice.
+func pagesOutput(pages []capturedPage, raw bool) (string, int, error) {
+	var parts []string
+	fullLength := 0
+	for i, page := range pages {
+		text := page.Content
+		if !raw {
+			converted, err := htmlToText(page.Content)
+			if err != nil {
+				return "", 0, err
+			}
+			fullLength += len(converted)
+			text = substituteFrames(cleanMarkdown(converted), page.Frames)
+		}
+		if len(pages) == 1 {
+			return text, fullLength, nil
+		}
+
+		if raw {
+			parts = append(parts, fmt.Sprintf("<!-- Page %d: %s -->\n%s", i+1, page.URL, text))
+		} else {
+			parts = append(parts, fmt.Sprintf("--- Page %d: %s ---\n\n%s", i+1, page.URL, text))
+		}
+	}
+	return strings.Join(parts, "\n\n"), fullLength, nil
+}
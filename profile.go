@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// profileLock is an advisory flock on <profile>.lock, held for the duration of a run
+// so that two invocations never drive Firefox against the same profile directory
+type profileLock struct {
+	file *os.File
+}
+
+// lockProfile takes an exclusive lock on the profile, retrying for up to wait
+// before reporting which process holds it
+func lockProfile(profileDir string, wait time.Duration) (*profileLock, error) {
+	file, err := acquireProfileLock(profileDir, syscall.LOCK_EX, wait)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record our PID so a competing run can tell the user who holds the profile
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+
+	return &profileLock{file: file}, nil
+}
+
+// Unlock releases the profile lock
+func (l *profileLock) Unlock() {
+	l.file.Truncate(0)
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
+
+// acquireProfileLock opens <profile>.lock and applies the given flock mode,
+// polling until wait has elapsed
+func acquireProfileLock(profileDir string, mode int, wait time.Duration) (*os.File, error) {
+	lockPath := profileDir + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open profile lock %s: %v", lockPath, err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err = syscall.Flock(int(file.Fd()), mode|syscall.LOCK_NB)
+		if err == nil {
+			return file, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("could not lock profile %s: %v", profileDir, err)
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	holder := lockHolder(lockPath)
+	file.Close()
+
+	name := filepath.Base(profileDir)
+	if wait > 0 {
+		return nil, fmt.Errorf("profile %q is in use by %s, gave up after waiting %s (use --profile-readonly to run against a copy)", name, holder, wait)
+	}
+	return nil, fmt.Errorf("profile %q is in use by %s (use --lock-wait <duration> to wait for it, or --profile-readonly to run against a copy)", name, holder)
+}
+
+// lockHolder describes the process whose PID is recorded in the lock file
+func lockHolder(lockPath string) string {
+	if data, err := os.ReadFile(lockPath); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return fmt.Sprintf("another web process (pid %d)", pid)
+		}
+	}
+	return "another web process"
+}
+
+// snapshotProfile copies the profile into a temporary directory. It never waits for the
+// profile lock: a shared lock is taken if it is free, keeping writers out during the copy,
+// and when a running session holds the profile it is copied anyway, with a warning
+func snapshotProfile(profileDir string) (string, error) {
+	lockPath := profileDir + ".lock"
+	if file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644); err == nil {
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+			defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		} else {
+			statusf("Warning: Profile %q is in use by %s; copying it anyway, the copy may be inconsistent", filepath.Base(profileDir), lockHolder(lockPath))
+		}
+		defer file.Close()
+	}
+
+	snapshotDir, err := os.MkdirTemp("", "web-profile-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create profile snapshot: %v", err)
+	}
+
+	if err := copyProfile(profileDir, snapshotDir); err != nil {
+		os.RemoveAll(snapshotDir)
+		return "", fmt.Errorf("could not snapshot profile: %v", err)
+	}
+
+	return snapshotDir, nil
+}
+
+// copyProfile recursively copies a Firefox profile, skipping Firefox's own lock files
+func copyProfile(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// Removed by a running session while we were copying
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		switch info.Name() {
+		case "lock", ".parentlock", "parent.lock":
+			return nil
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			// Sockets and symlinks (e.g. Firefox's lock symlink) aren't needed in a snapshot
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// replHelp lists the commands understood by `web repl`
+const replHelp = `Commands:
+  goto <url>               Navigate to a URL (paths resolve against the current page)
+  click <css>              Click an element and wait for any navigation it triggers
+  fill <css> <value>       Clear an input and type the value (quote selectors containing spaces;
+                           @secret:env:VAR and @secret:file:PATH read the value as a secret)
+  js <code>                Run JavaScript and print its return value
+  text [css]               Print the page as markdown, or the text of matching elements
+  screenshot <path>        Save a screenshot
+  back, forward, reload    Browser history navigation
+  help                     Show this list
+  quit                     Close the session`
+
+// replResponse is the framed result of one REPL command
+type replResponse struct {
+	Command  string `json:"command"`
+	OK       bool   `json:"ok"`
+	URL      string `json:"url,omitempty"`
+	Duration int64  `json:"duration_ms"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runREPL keeps one browser session open and executes line-oriented commands read from
+// stdin, writing one framed response per command to stdout
+func runREPL(config Config) error {
+	session, err := startSession(config)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	runner := &flowRunner{config: config, session: session}
+	if config.URL != "" {
+		writeREPLResponse(os.Stdout, config.JSONFlag, runREPLCommand(runner, "goto "+config.URL))
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	// Allow long js commands
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			break
+		}
+		writeREPLResponse(os.Stdout, config.JSONFlag, runREPLCommand(runner, line))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read commands: %v", err)
+	}
+
+	// Save recorded network traffic
+	if err := session.SaveHAR(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runREPLCommand executes one command line, reusing the flow step implementations
+func runREPLCommand(runner *flowRunner, line string) replResponse {
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	seenDialogs := 0
+	if runner.session.events != nil {
+		seenDialogs = len(runner.session.events.Dialogs())
+	}
+
+	start := time.Now()
+	output, err := replCommand(runner, command, arg)
+	if runner.session.events != nil {
+		// Report dialogs the command caused alongside its output
+		for _, d := range runner.session.events.Dialogs()[seenDialogs:] {
+			output = strings.TrimPrefix(output+"\nDialog: "+formatDialog(d), "\n")
+		}
+	}
+	response := replResponse{
+		Command:  command,
+		OK:       err == nil,
+		Duration: time.Since(start).Milliseconds(),
+		Output:   redact(output),
+	}
+	if err != nil {
+		response.Error = redact(err.Error())
+	}
+	if currentURL, err := runner.session.wd.CurrentURL(); err == nil {
+		response.URL = redact(currentURL)
+	}
+	return response
+}
+
+func replCommand(runner *flowRunner, command, arg string) (string, error) {
+	wd := runner.session.wd
+	requireArg := func(usage string) error {
+		if arg == "" {
+			return fmt.Errorf("usage: %s", usage)
+		}
+		return nil
+	}
+
+	switch command {
+	case "help":
+		return replHelp, nil
+	case "goto":
+		if err := requireArg("goto <url>"); err != nil {
+			return "", err
+		}
+		return runner.run(&flowStep{Goto: &arg})
+	case "click":
+		if err := requireArg("click <css>"); err != nil {
+			return "", err
+		}
+		return runner.run(&flowStep{Click: &arg})
+	case "fill":
+		selector, value, err := splitSelector(arg)
+		if err != nil {
+			return "", err
+		}
+		if selector == "" {
+			return "", fmt.Errorf("usage: fill <css> <value>")
+		}
+		value, err = resolveInputValue("--value", value)
+		if err != nil {
+			return "", err
+		}
+		return runner.run(&flowStep{Fill: &flowFill{Selector: selector, Value: value}})
+	case "js":
+		if err := requireArg("js <code>"); err != nil {
+			return "", err
+		}
+		// Unlike a flow's js step, follow any navigation the script starts, as a click would
+		previousURL, _ := wd.CurrentURL()
+		if err := markDocument(wd); err != nil {
+			return "", err
+		}
+		output, err := runner.run(&flowStep{JS: &arg})
+		if err != nil {
+			return "", err
+		}
+		if waitForNavigation(wd, previousURL, runner.isLiveView, runner.config) && !runner.isLiveView {
+			runner.isLiveView = detectLiveView(wd)
+		}
+		return output, nil
+	case "text":
+		return runner.run(&flowStep{Extract: &flowExtract{Selector: arg}})
+	case "screenshot":
+		if err := requireArg("screenshot <path>"); err != nil {
+			return "", err
+		}
+		if _, err := runner.run(&flowStep{Screenshot: &arg}); err != nil {
+			return "", err
+		}
+		return "Screenshot saved to " + arg, nil
+	case "back", "forward", "reload":
+		var err error
+		switch command {
+		case "back":
+			err = wd.Back()
+		case "forward":
+			err = wd.Forward()
+		case "reload":
+			err = wd.Refresh()
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not %s: %v", command, err)
+		}
+		runner.isLiveView = detectLiveView(wd)
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown command %q (try help)", command)
+}
+
+// splitSelector splits "<css> <rest>", where a selector containing spaces is quoted
+func splitSelector(arg string) (string, string, error) {
+	if arg == "" {
+		return "", "", nil
+	}
+	if quote := arg[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(arg[1:], quote)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quoted selector")
+		}
+		return arg[1 : end+1], strings.TrimSpace(arg[end+2:]), nil
+	}
+	selector, rest, _ := strings.Cut(arg, " ")
+	return selector, strings.TrimSpace(rest), nil
+}
+
+// writeREPLResponse writes a response as a single JSON line, or as a plain-text frame:
+//
+//	=== goto ok (412ms) https://example.com/
+//	Status: 200 OK
+//	=== end
+func writeREPLResponse(w io.Writer, asJSON bool, response replResponse) {
+	if asJSON {
+		data, _ := json.Marshal(response)
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	status := "ok"
+	if !response.OK {
+		status = "error"
+	}
+	fmt.Fprintf(w, "=== %s %s (%s) %s\n", response.Command, status, time.Duration(response.Duration)*time.Millisecond, response.URL)
+	if response.Error != "" {
+		fmt.Fprintln(w, response.Error)
+	}
+	if response.Output != "" {
+		fmt.Fprintln(w, response.Output)
+	}
+	fmt.Fprintln(w, "=== end")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+const DEFAULT_MAX_SCROLLS = 20
+
+const DEFAULT_SCROLL_DELAY = 1 * time.Second
+
+// pageHeightScript returns the current scrollable height of the page
+const pageHeightScript = `return Math.max(
+	document.documentElement ? document.documentElement.scrollHeight : 0,
+	document.body ? document.body.scrollHeight : 0)`
+
+// eagerLoadScript switches loading=lazy images and iframes to eager loading, so ones the
+// scroll jumped past are fetched too, and marks iframes once they have loaded
+const eagerLoadScript = `
+	document.querySelectorAll('iframe').forEach(function(frame) {
+		if (frame.__webLoadTracked) return;
+		frame.__webLoadTracked = true;
+		try {
+			if (frame.contentDocument && frame.contentDocument.readyState === 'complete') frame.__webLoaded = true;
+		} catch (e) {}
+		frame.addEventListener('load', function() { frame.__webLoaded = true; });
+		frame.addEventListener('error', function() { frame.__webLoaded = true; });
+	});
+	document.querySelectorAll('img[loading=lazy], iframe[loading=lazy]').forEach(function(el) {
+		el.loading = 'eager';
+	});
+`
+
+// lazyContentLoadedScript is true once every image has finished (or failed) loading and
+// every lazy iframe has loaded; cross-origin frames that loaded before tracking started are
+// recognised by their resource timing entry
+const lazyContentLoadedScript = `
+	var images = Array.prototype.every.call(document.images, function(img) { return img.complete; });
+	var loadedFrames = performance.getEntriesByType('resource').filter(function(entry) {
+		return entry.initiatorType === 'iframe';
+	}).map(function(entry) { return entry.name; });
+	var frames = Array.prototype.every.call(document.querySelectorAll('iframe[loading]'), function(frame) {
+		return frame.__webLoaded || !frame.src || loadedFrames.indexOf(frame.src) !== -1;
+	});
+	return images && frames;
+`
+
+// scrollPage scrolls to the bottom of the page until its height stops growing or maxScrolls
+// is reached, then waits for lazy images and iframes. It returns the number of scrolls.
+func scrollPage(wd selenium.WebDriver, maxScrolls int, delay time.Duration, timeout time.Duration) int {
+	lastHeight, err := pageHeight(wd)
+	if err != nil {
+		statusf("Warning: Could not measure page height: %v", err)
+		return 0
+	}
+
+	statusf("Scrolling page...")
+	scrolls := 0
+	for scrolls < maxScrolls {
+		if _, err := wd.ExecuteScript("window.scrollTo(0, document.documentElement.scrollHeight || document.body.scrollHeight)", nil); err != nil {
+			statusf("Warning: Scrolling failed: %v", err)
+			break
+		}
+		scrolls++
+
+		// Give infinite scroll handlers time to fetch and render the next batch
+		time.Sleep(delay)
+
+		height, err := pageHeight(wd)
+		if err != nil {
+			statusf("Warning: Could not measure page height: %v", err)
+			break
+		}
+		if height <= lastHeight {
+			break
+		}
+		lastHeight = height
+	}
+	if scrolls == maxScrolls {
+		statusf("Stopped after --max-scrolls %d, page may have more content", maxScrolls)
+	}
+	statusf("Scrolled %d time(s), page height %dpx", scrolls, lastHeight)
+
+	if _, err := wd.ExecuteScript(eagerLoadScript, nil); err != nil {
+		statusf("Warning: Could not trigger lazy loading: %v", err)
+		return scrolls
+	}
+	statusf("Waiting for lazy images and iframes...")
+	if err := waitForFunction(wd, lazyContentLoadedScript, timeout); err != nil {
+		statusf("Warning: Lazy images and iframes did not finish loading within %s", timeout)
+	}
+	return scrolls
+}
+
+func pageHeight(wd selenium.WebDriver) (int, error) {
+	result, err := wd.ExecuteScript(pageHeightScript, nil)
+	if err != nil {
+		return 0, err
+	}
+	height, ok := result.(float64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected page height %v", result)
+	}
+	return int(height), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const secretPrefix = "@secret:"
+
+// secretValues holds every secret resolved from the command line; they are masked
+// in status messages, errors and collected console output
+var secretValues []string
+
+var stdinConsumed bool
+
+// stdinCommands is set by web repl, whose commands arrive on stdin, so no value is read from it
+var stdinCommands bool
+
+// statusOutput receives progress messages; --json moves them to stderr
+var statusOutput io.Writer = os.Stdout
+
+// addSecret registers a value to be redacted from all output
+func addSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretValues = append(secretValues, value)
+	// Replace longer secrets first so one secret containing another is fully masked
+	sort.Slice(secretValues, func(i, j int) bool {
+		return len(secretValues[i]) > len(secretValues[j])
+	})
+}
+
+// redact masks any known secret values in s
+func redact(s string) string {
+	for _, secret := range secretValues {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	return s
+}
+
+// statusf prints a progress message with secrets masked
+func statusf(format string, args ...interface{}) {
+	fmt.Fprintln(statusOutput, redact(fmt.Sprintf(format, args...)))
+}
+
+// resolveInputValue reads a form value from the source named by flag.
+// Values read from the environment, files or stdin are always treated as secrets,
+// as are --value arguments using the @secret:<env|file|stdin> reference syntax.
+func resolveInputValue(flag, arg string) (string, error) {
+	switch flag {
+	case "--value":
+		if !strings.HasPrefix(arg, secretPrefix) {
+			return arg, nil
+		}
+		ref := strings.TrimPrefix(arg, secretPrefix)
+		source, target, _ := strings.Cut(ref, ":")
+		switch source {
+		case "env":
+			return resolveInputValue("--value-env", target)
+		case "file":
+			return resolveInputValue("--value-file", target)
+		case "stdin":
+			return resolveInputValue("--value-stdin", "")
+		}
+		return "", fmt.Errorf("invalid secret reference %q (expected %senv:VAR, %sfile:PATH or %sstdin)", arg, secretPrefix, secretPrefix, secretPrefix)
+	case "--value-env":
+		value, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+		addSecret(value)
+		return value, nil
+	case "--value-file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", fmt.Errorf("could not read value file: %v", err)
+		}
+		value := trimTrailingNewline(string(data))
+		addSecret(value)
+		return value, nil
+	case "--value-stdin":
+		if stdinCommands {
+			return "", fmt.Errorf("stdin carries REPL commands and cannot provide a secret (read it from the environment or a file instead)")
+		}
+		if stdinConsumed {
+			return "", fmt.Errorf("stdin can only provide one value")
+		}
+		stdinConsumed = true
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("could not read value from stdin: %v", err)
+		}
+		value := trimTrailingNewline(string(data))
+		addSecret(value)
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown value source %s", flag)
+}
+
+// trimTrailingNewline strips the single line ending left by editors and `echo`
+func trimTrailingNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+// browserSession is a running Firefox instance driven over WebDriver, with its profile
+// held for the lifetime of the session
+type browserSession struct {
+	wd          selenium.WebDriver
+	events      *eventCapture    // nil when WebDriver BiDi is unavailable
+	recorder    *harRecorder     // nil unless --har is set
+	harPath     string           // where SaveHAR writes the recording
+	downloads   *downloadWatcher // nil unless --download-dir is set
+	mayDownload bool             // set once an action that can start a download has run
+	cleanup     []func()
+}
+
+// startSession starts geckodriver and Firefox for the configured profile and begins
+// capturing console, error and network events. Callers must Close the session.
+func startSession(config Config) (*browserSession, error) {
+	session := &browserSession{}
+	ok := false
+	defer func() {
+		if !ok {
+			session.Close()
+		}
+	}()
+
+	// Get Firefox and geckodriver paths (checks PATH first, then falls back to ~/.web-firefox/)
+	firefoxExec := getFirefoxPath()
+	geckoDriverPath := getGeckodriverPath()
+
+	// Get home directory for profile storage
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not get home directory: %v", err)
+	}
+
+	// Configure Firefox with profile (profiles always stored in ~/.web-firefox/profiles/)
+	profileDir := filepath.Join(homeDir, ".web-firefox", "profiles", config.Profile)
+	os.MkdirAll(profileDir, 0755)
+
+	if config.ReadonlyFlag {
+		// Run against a throwaway copy so the real profile is never written to
+		snapshotDir, err := snapshotProfile(profileDir)
+		if err != nil {
+			return nil, err
+		}
+		session.onClose(func() { os.RemoveAll(snapshotDir) })
+		profileDir = snapshotDir
+	} else {
+		// Hold the profile exclusively so concurrent runs can't share it
+		lock, err := lockProfile(profileDir, config.LockWait)
+		if err != nil {
+			return nil, err
+		}
+		session.onClose(lock.Unlock)
+	}
+
+	// Start geckodriver on a port of its own, only once the profile is ours, so concurrent
+	// runs never reach each other's driver
+	port, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("could not find a port for geckodriver: %v", err)
+	}
+	service, err := selenium.NewGeckoDriverService(geckoDriverPath, port)
+	if err != nil {
+		return nil, fmt.Errorf("could not start geckodriver service: %v", err)
+	}
+	session.onClose(func() { service.Stop() })
+
+	prefs := map[string]interface{}{
+		"devtools.console.stdout.content": true,
+	}
+
+	// Save downloads into --download-dir without prompting
+	if config.DownloadDir != "" {
+		session.downloads, err = watchDownloads(config.DownloadDir)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range downloadPrefs(config.DownloadDir) {
+			prefs[name] = value
+		}
+	}
+	caps := selenium.Capabilities{
+		"browserName":             "firefox",
+		"unhandledPromptBehavior": config.Dialog.promptBehavior(),
+		"moz:firefoxOptions": map[string]interface{}{
+			"binary": firefoxExec,
+			"args":   []string{"-headless", "-profile", profileDir},
+			"prefs":  prefs,
+			"log": map[string]interface{}{
+				"level": "trace",
+			},
+		},
+	}
+
+	// Route all traffic through the recording proxy when exporting a HAR
+	if config.HARPath != "" {
+		session.recorder, err = startHARRecorder(profileDir, config.HARBodyLimit)
+		if err != nil {
+			return nil, err
+		}
+		session.onClose(session.recorder.Close)
+		session.harPath = config.HARPath
+
+		caps["proxy"] = map[string]interface{}{
+			"proxyType": "manual",
+			"httpProxy": session.recorder.Addr(),
+			"sslProxy":  session.recorder.Addr(),
+		}
+		// The proxy re-signs HTTPS with its own CA, which startHARRecorder has added to the
+		// profile's trust store; certificate checks stay on in both browser and proxy
+		prefs["network.proxy.allow_hijacking_localhost"] = true
+		prefs["network.proxy.no_proxies_on"] = ""
+	}
+
+	// Create WebDriver, with a WebDriver BiDi connection for browser events
+	bidi := enableBiDi()
+	session.wd, err = selenium.NewRemote(caps, fmt.Sprintf("http://localhost:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("could not create webdriver: %v", err)
+	}
+	session.onClose(func() { session.wd.Quit() })
+
+	// Subscribe to console, error and network events before the first navigation
+	session.events, err = startEventCapture(bidi.webSocketURL, config.Dialog)
+	if err != nil {
+		statusf("Warning: WebDriver BiDi unavailable, console and network events will not be captured: %v", err)
+		if config.Dialog.Text != "" {
+			statusf("Warning: --dialog text= needs WebDriver BiDi, prompts will be left open")
+		}
+	} else {
+		session.onClose(session.events.Close)
+	}
+
+	ok = true
+	return session, nil
+}
+
+// freePort asks the OS for an unused local TCP port
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// onClose registers a cleanup function to run when the session is closed
+func (s *browserSession) onClose(fn func()) {
+	s.cleanup = append(s.cleanup, fn)
+}
+
+// Close shuts down the browser and releases the profile, in reverse order of setup
+func (s *browserSession) Close() {
+	for i := len(s.cleanup) - 1; i >= 0; i-- {
+		s.cleanup[i]()
+	}
+	s.cleanup = nil
+}
+
+// Diagnostics collects the console messages, failed requests and JavaScript errors seen so far
+func (s *browserSession) Diagnostics(config Config) ([]string, []networkError, []pageError) {
+	if s.events == nil {
+		return nil, nil, nil
+	}
+	s.events.Flush()
+	var consoleMessages []string
+	if !config.NoConsole {
+		consoleMessages = s.events.ConsoleMessages(config.Console)
+	}
+	return consoleMessages, s.events.NetworkErrors(), s.events.Errors()
+}
+
+// ExpectDownload notes that a click, form submission or script has run, any of which can
+// start a download, so Downloads gives one time to appear
+func (s *browserSession) ExpectDownload() {
+	s.mayDownload = true
+}
+
+// Downloads waits for downloads into --download-dir to finish and returns the new files
+func (s *browserSession) Downloads(timeout time.Duration) []downloadedFile {
+	if s.downloads == nil {
+		return nil
+	}
+	return s.downloads.Wait(s.mayDownload, timeout)
+}
+
+// Dialogs returns the alert, confirm, prompt and beforeunload dialogs opened so far
+func (s *browserSession) Dialogs() []dialogRecord {
+	if s.events == nil {
+		return nil
+	}
+	return s.events.Dialogs()
+}
+
+// SaveHAR writes the traffic recorded so far to the --har file, if recording
+func (s *browserSession) SaveHAR() error {
+	if s.recorder == nil {
+		return nil
+	}
+	count, err := s.recorder.WriteFile(s.harPath)
+	if err != nil {
+		return fmt.Errorf("error saving HAR: %v", err)
+	}
+	statusf("HAR saved to %s (%d entries)", s.harPath, count)
+	return nil
+}
+
+// detectLiveView reports whether the current page is a Phoenix LiveView and, if so, waits
+// for it to connect and installs the listeners used to track LiveView navigation
+func detectLiveView(wd selenium.WebDriver) bool {
+	isLiveView, err := wd.ExecuteScript("return document.querySelector('[data-phx-session]') !== null", nil)
+	if err != nil || isLiveView != true {
+		return false
+	}
+
+	statusf("Detected Phoenix LiveView page, waiting for connection...")
+	// Wait for Phoenix LiveView to connect
+	err = waitForSelector(wd, ".phx-connected", 10*time.Second)
+	if err != nil {
+		statusf("Warning: Could not detect LiveView connection: %v", err)
+	} else {
+		statusf("Phoenix LiveView connected")
+	}
+
+	// Set up navigation tracking using Phoenix events for all page interactions
+	_, err = wd.ExecuteScript(`
+		if (!window.__phxNavigationState) {
+			window.__phxNavigationState = { loading: false };
+			document.addEventListener('phx:page-loading-start', function() {
+				window.__phxNavigationState.loading = true;
+			});
+			document.addEventListener('phx:page-loading-stop', function() {
+				window.__phxNavigationState.loading = false;
+			});
+		}
+	`, nil)
+	if err != nil {
+		statusf("Warning: Could not inject Phoenix navigation listeners: %v", err)
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tebeka/selenium"
+)
+
+// shadowDOMMode is set by --shadow-dom: page content is serialized with open shadow roots
+// flattened into their hosts, and CSS selectors also match inside open shadow roots
+var shadowDOMMode bool
+
+// queryAllScript defines webQueryAll(selector, deep), which returns the elements matching
+// selector in the document and, when deep, in every open shadow root. Each selector is
+// matched within one tree, so combinators don't cross a shadow boundary.
+const queryAllScript = `
+	function webQueryAll(selector, deep) {
+		var results = Array.prototype.slice.call(document.querySelectorAll(selector));
+		if (!deep) return results;
+		(function walk(root) {
+			var all = root.querySelectorAll('*');
+			for (var i = 0; i < all.length; i++) {
+				var shadow = all[i].shadowRoot;
+				if (shadow) {
+					results.push.apply(results, shadow.querySelectorAll(selector));
+					walk(shadow);
+				}
+			}
+		})(document);
+		return results;
+	}
+`
+
+// flattenShadowScript serializes the document with each open shadow root rendered in place of
+// its host's children, slots replaced by the nodes assigned to them, and unattached declarative
+// shadow roots (<template shadowrootmode>) expanded. An optional argument maps
+// data-web-frame indexes to placeholders that replace those iframes.
+const flattenShadowScript = `
+	var frameKeys = arguments[0] || {};
+	var voidElements = /^(area|base|br|col|embed|hr|img|input|link|meta|source|track|wbr)$/;
+	var rawText = /^(script|style)$/;
+	function escapeText(s) {
+		return s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+	}
+	function escapeAttr(s) {
+		return s.replace(/&/g, '&amp;').replace(/"/g, '&quot;');
+	}
+	function serializeChildren(nodes, inShadow) {
+		var html = '';
+		for (var i = 0; i < nodes.length; i++) html += serialize(nodes[i], inShadow);
+		return html;
+	}
+	function serialize(node, inShadow) {
+		if (node.nodeType === Node.TEXT_NODE) {
+			var parent = node.parentNode && node.parentNode.localName;
+			return rawText.test(parent || '') ? node.data : escapeText(node.data);
+		}
+		if (node.nodeType !== Node.ELEMENT_NODE) return '';
+
+		var tag = node.localName;
+		if (tag === 'iframe' && frameKeys[node.getAttribute('data-web-frame')]) {
+			return '<p>' + frameKeys[node.getAttribute('data-web-frame')] + '</p>';
+		}
+		if (tag === 'slot' && inShadow) {
+			var assigned = node.assignedNodes({ flatten: true });
+			return serializeChildren(assigned.length ? assigned : node.childNodes, inShadow);
+		}
+		if (tag === 'template' && node.hasAttribute('shadowrootmode')) {
+			return serializeChildren(node.content.childNodes, true);
+		}
+
+		var html = '<' + tag;
+		for (var i = 0; i < node.attributes.length; i++) {
+			html += ' ' + node.attributes[i].name + '="' + escapeAttr(node.attributes[i].value) + '"';
+		}
+		html += '>';
+		if (voidElements.test(tag)) return html;
+		if (node.shadowRoot) {
+			html += serializeChildren(node.shadowRoot.childNodes, true);
+		} else {
+			html += serializeChildren(node.childNodes, inShadow);
+		}
+		return html + '</' + tag + '>';
+	}
+	return '<!DOCTYPE html>' + serialize(document.documentElement, false);
+`
+
+// pageSource returns the current document's HTML, flattening shadow roots in shadow DOM mode
+func pageSource(wd selenium.WebDriver) (string, error) {
+	if !shadowDOMMode {
+		return wd.PageSource()
+	}
+	result, err := wd.ExecuteScript(flattenShadowScript, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not serialize shadow DOM: %v", err)
+	}
+	content, ok := result.(string)
+	if !ok {
+		return "", fmt.Errorf("could not serialize shadow DOM: unexpected result %T", result)
+	}
+	return content, nil
+}
+
+// findElements returns the elements matching a CSS selector, including inside open shadow
+// roots in shadow DOM mode
+func findElements(wd selenium.WebDriver, selector string) ([]selenium.WebElement, error) {
+	if !shadowDOMMode {
+		return wd.FindElements(selenium.ByCSSSelector, selector)
+	}
+	raw, err := wd.ExecuteScriptRaw(queryAllScript+"return webQueryAll(arguments[0], true);", []interface{}{selector})
+	if err != nil {
+		return nil, err
+	}
+	return wd.DecodeElements(raw)
+}
+
+// findElement returns the first element matching a CSS selector, including inside open
+// shadow roots in shadow DOM mode
+func findElement(wd selenium.WebDriver, selector string) (selenium.WebElement, error) {
+	if !shadowDOMMode {
+		return wd.FindElement(selenium.ByCSSSelector, selector)
+	}
+	elems, err := findElements(wd, selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("no such element: unable to locate %s (searched open shadow roots)", selector)
+	}
+	return elems[0], nil
+}
+
+// queryScript returns a JavaScript expression evaluating to the first element matching
+// selector, honouring shadow DOM mode
+func queryScript(selector string) string {
+	return fmt.Sprintf("(function() { %s return webQueryAll(%s, %t)[0] || null; })()", queryAllScript, jsString(selector), shadowDOMMode)
+}
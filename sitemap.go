@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sitemapMaxNesting bounds sitemap indexes that list further indexes
+const sitemapMaxNesting = 3
+
+// sitemapMaxSize is the protocol's limit on an uncompressed sitemap
+const sitemapMaxSize = 50 * 1024 * 1024
+
+// sitemapDocument is either a <urlset> or a <sitemapindex>
+type sitemapDocument struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapDateLayouts are the W3C datetime forms allowed for <lastmod>
+var sitemapDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// loadSitemap returns the page URLs listed by a sitemap, following sitemap indexes and
+// decompressing gzipped sitemaps. Entries last modified before since are left out;
+// entries without a <lastmod> are kept.
+func loadSitemap(sitemapURL string, since time.Time, timeout time.Duration) ([]string, error) {
+	client := &http.Client{Timeout: timeout}
+	seen := map[string]bool{}
+	var urls []string
+
+	var load func(location string, nesting int) error
+	load = func(location string, nesting int) error {
+		if seen[location] {
+			return nil
+		}
+		seen[location] = true
+
+		statusf("Reading sitemap %s", location)
+		doc, err := fetchSitemap(client, location)
+		if err != nil {
+			return err
+		}
+		for _, entry := range doc.URLs {
+			if entry.Loc = strings.TrimSpace(entry.Loc); entry.Loc != "" && sitemapModifiedSince(entry, since) {
+				urls = append(urls, entry.Loc)
+			}
+		}
+		for _, entry := range doc.Sitemaps {
+			entry.Loc = strings.TrimSpace(entry.Loc)
+			if entry.Loc == "" || !sitemapModifiedSince(entry, since) {
+				continue
+			}
+			if nesting+1 >= sitemapMaxNesting {
+				statusf("Warning: Skipping sitemap %s (nested more than %d levels)", entry.Loc, sitemapMaxNesting)
+				continue
+			}
+			// One broken sitemap in an index shouldn't lose the others
+			if err := load(entry.Loc, nesting+1); err != nil {
+				statusf("Warning: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if err := load(sitemapURL, 0); err != nil {
+		return nil, err
+	}
+	statusf("Sitemap lists %d page(s)", len(urls))
+	return urls, nil
+}
+
+// fetchSitemap downloads and parses one sitemap, gunzipping it when compressed
+func fetchSitemap(client *http.Client, location string) (*sitemapDocument, error) {
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch sitemap %s: %v", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch sitemap %s: HTTP %s", location, resp.Status)
+	}
+
+	// Detect gzip by its magic bytes: .xml.gz files are often served without Content-Encoding
+	body := bufio.NewReader(resp.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress sitemap %s: %v", location, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(io.LimitReader(reader, sitemapMaxSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not parse sitemap %s: %v", location, err)
+	}
+	return &doc, nil
+}
+
+// sitemapModifiedSince is true when the entry has no usable <lastmod> or was modified at
+// or after since
+func sitemapModifiedSince(entry sitemapEntry, since time.Time) bool {
+	if since.IsZero() {
+		return true
+	}
+	lastMod, err := parseSitemapDate(strings.TrimSpace(entry.LastMod))
+	if err != nil {
+		return true
+	}
+	return !lastMod.Before(since)
+}
+
+// parseSitemapDate parses a W3C datetime, as used by <lastmod> and --since
+func parseSitemapDate(value string) (time.Time, error) {
+	for _, layout := range sitemapDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (expected e.g. 2024-01-31 or 2024-01-31T12:00:00Z)", value)
+}